@@ -0,0 +1,264 @@
+package generic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// BatchConfig configures how results are grouped into batches before they are written. A batch is written as soon as
+// any of the limits that are set is reached.
+type BatchConfig struct {
+	// MaxItems is the number of results in a full batch
+	MaxItems int
+	// MaxBytes is the total size of the results in a full batch, as returned by Size
+	MaxBytes int
+	// MaxLatency is the longest time a result waits in a batch before the batch is written
+	MaxLatency time.Duration
+	// Size returns the size in bytes of a result. It defaults to the length of []byte and string results.
+	Size func(result interface{}) int
+}
+
+// BatchWriter can be implemented by io.WriteClosers that can write several encoded results at once, such as bulk APIs
+type BatchWriter interface {
+	WriteBatch(b [][]byte) (int, error)
+}
+
+// batchPipeWriter is a pipeWriter which can write a batch of results at once if batches returns true
+type batchPipeWriter interface {
+	WriteBatch(results []interface{}) (int, error)
+	batches() bool
+}
+
+// WithBatching groups the results of the pipeline into batches before they are written. Writers whose encoder
+// implements pencode.BatchEncoder or whose io.WriteCloser implements BatchWriter receive the whole batch, all other
+// writers receive the results of the batch one at a time.
+func WithBatching(cfg BatchConfig) Option {
+	return func(p *Pipeline) {
+		if cfg.Size == nil {
+			cfg.Size = defaultSize
+		}
+		p.batcher = &batcher{cfg: cfg}
+	}
+}
+
+// defaultSize returns the length of []byte and string results and zero for everything else
+func defaultSize(result interface{}) int {
+	switch v := payloadOf(result).(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	return 0
+}
+
+// batcher groups results into batches
+type batcher struct {
+	cfg BatchConfig
+
+	mu      sync.Mutex
+	batch   Results
+	bytes   int
+	started time.Time
+}
+
+// add adds the results to the current batch and returns the batches that are full
+func (b *batcher) add(results Results, now time.Time) []Results {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var full []Results
+	for _, result := range results {
+		if len(b.batch) == 0 {
+			b.started = now
+		}
+		b.batch = append(b.batch, result)
+		b.bytes += b.cfg.Size(result)
+		if (b.cfg.MaxItems > 0 && len(b.batch) >= b.cfg.MaxItems) || (b.cfg.MaxBytes > 0 && b.bytes >= b.cfg.MaxBytes) {
+			full = append(full, b.take())
+		}
+	}
+	return full
+}
+
+// expire returns the current batch if it has waited longer than the maximum latency
+func (b *batcher) expire(now time.Time) Results {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.batch) == 0 || b.cfg.MaxLatency <= 0 || now.Sub(b.started) < b.cfg.MaxLatency {
+		return nil
+	}
+	return b.take()
+}
+
+// flush returns the current batch
+func (b *batcher) flush() Results {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.take()
+}
+
+// take returns the current batch and starts a new one
+func (b *batcher) take() Results {
+	batch := b.batch
+	b.batch, b.bytes = nil, 0
+	return batch
+}
+
+// tick returns how often the batch is checked for exceeding its maximum latency
+func (b *batcher) tick() time.Duration {
+	if b.cfg.MaxLatency <= 0 {
+		return 0
+	}
+	tick := b.cfg.MaxLatency / 4
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	return tick
+}
+
+// startBatcher periodically writes batches which exceed their maximum latency until the returned function is called,
+// which writes the remaining batch
+func (p *Pipeline) startBatcher(ctx context.Context) (stop func()) {
+	if p.batcher == nil {
+		return func() {}
+	}
+	stopTicker := p.every(p.batcher.tick(), func(now time.Time) {
+		p.writeBatches(ctx, p.batcher.expire(now))
+	})
+	return func() {
+		stopTicker()
+		p.writeBatches(ctx, p.batcher.flush())
+	}
+}
+
+// writeBatches writes each of the batches, reporting any errors to the error handler
+func (p *Pipeline) writeBatches(ctx context.Context, batches ...Results) {
+	for _, batch := range batches {
+		if err := p.writeBatch(ctx, batch); err != nil {
+			p.handleError(ctx, err)
+		}
+	}
+}
+
+// writeBatch writes the batch to the writers selected by the router for each result. The results of a batch that
+// fails to write are sent to the dead-letter sink unless the error policy skips them.
+func (p *Pipeline) writeBatch(ctx context.Context, batch Results) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// Group the results by writer, keeping the order of the batch
+	var writers []pipeWriter
+	perWriter := make(map[pipeWriter][]interface{})
+	for _, result := range batch {
+		for _, w := range p.route(result) {
+			if _, ok := perWriter[w]; !ok {
+				writers = append(writers, w)
+			}
+			perWriter[w] = append(perWriter[w], result)
+		}
+	}
+
+	var errors []error
+	for _, w := range writers {
+		bw := &batchWrite{w: w, results: perWriter[w]}
+		err := p.writeTo(ctx, nil, w, bw.results, bw.write)
+		if err != nil {
+			p.log.Error("Error during write: %s", err)
+			for _, result := range bw.remaining() {
+				if !skipped(err) {
+					p.deadLetter(StageWrite, nil, nil, payloadOf(result), err)
+				}
+			}
+			errors = append(errors, err)
+		}
+	}
+	return combineErrors(errors...)
+}
+
+// batchWrite writes a batch of results to a pipeWriter at once if it supports it, otherwise one at a time. Results
+// written one at a time are counted so that a retry only writes the results which were not written yet.
+type batchWrite struct {
+	w       pipeWriter
+	results []interface{}
+	written int
+}
+
+func (b *batchWrite) write() (int, error) {
+	if bw, ok := b.w.(batchPipeWriter); ok && bw.batches() {
+		return bw.WriteBatch(b.results)
+	}
+	var total int
+	for ; b.written < len(b.results); b.written++ {
+		n, err := b.w.Write(b.results[b.written])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// remaining returns the results which were not written
+func (b *batchWrite) remaining() []interface{} {
+	return b.results[b.written:]
+}
+
+// batches returns true if the encoder implements pencode.BatchEncoder or the io.WriteCloser implements BatchWriter
+func (p *pipeOutput) batches() bool {
+	_, encodes := p.enc.(pencode.BatchEncoder)
+	_, writes := p.w.(BatchWriter)
+	return encodes || writes
+}
+
+// WriteBatch encodes the results as a whole if the encoder implements pencode.BatchEncoder, or one at a time if the
+// io.WriteCloser implements BatchWriter. It must only be called if batches returns true.
+func (p *pipeOutput) WriteBatch(results []interface{}) (n int, err error) {
+	stage := StageEncode
+	defer func() {
+		if v := recover(); v != nil {
+			err = newPanicError(stage, results, v)
+		}
+	}()
+
+	payloads := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		payloads = append(payloads, payloadOf(result))
+	}
+
+	if enc, ok := p.enc.(pencode.BatchEncoder); ok {
+		raw, err := enc.EncodeBatch(payloads)
+		if err != nil {
+			return 0, &StageError{Stage: StageEncode, Writer: sourceName(p.w), Err: err}
+		}
+		stage = StageWrite
+		return p.w.Write(raw)
+	}
+
+	bw := p.w.(BatchWriter)
+	raws := make([][]byte, 0, len(payloads))
+	for _, payload := range payloads {
+		raw, err := p.enc.Encode(payload)
+		if err != nil {
+			return 0, &StageError{Stage: StageEncode, Writer: sourceName(p.w), Err: err}
+		}
+		raws = append(raws, raw)
+	}
+	stage = StageWrite
+	return bw.WriteBatch(raws)
+}
+
+// WriteBatch writes the batch to the underlying writer
+func (w *routedWriter) WriteBatch(results []interface{}) (int, error) {
+	return w.pipeWriter.(batchPipeWriter).WriteBatch(results)
+}
+
+// batches returns true if the underlying writer can write a batch at once
+func (w *routedWriter) batches() bool {
+	bw, ok := w.pipeWriter.(batchPipeWriter)
+	return ok && bw.batches()
+}
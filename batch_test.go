@@ -0,0 +1,176 @@
+package generic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// batchWriter records each batch it receives
+type batchWriter struct {
+	mu      sync.Mutex
+	batches [][]string
+	writes  int
+}
+
+func (w *batchWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return len(b), nil
+}
+
+func (w *batchWriter) WriteBatch(b [][]byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var batch []string
+	for _, raw := range b {
+		batch = append(batch, string(raw))
+	}
+	w.batches = append(w.batches, batch)
+	return len(b), nil
+}
+
+func (w *batchWriter) Close() error { return nil }
+
+// bufferCloser is a bytes.Buffer that records each write separately
+type bufferCloser struct {
+	bytes.Buffer
+	writes []string
+}
+
+func (b *bufferCloser) Write(p []byte) (int, error) {
+	b.writes = append(b.writes, string(p))
+	return b.Buffer.Write(p)
+}
+
+func (b *bufferCloser) Close() error { return nil }
+
+func newBatchedPipeline(cfg BatchConfig, payloads ...interface{}) *Pipeline {
+	p := newQuietPipeline(WithBatching(cfg))
+	p.AddSource(&sliceSource{payloads: payloads})
+	p.SetProcessor(identity)
+	return p
+}
+
+func TestBatchWriter(t *testing.T) {
+	p := newBatchedPipeline(BatchConfig{MaxItems: 2}, "a", "b", "c", "d", "e")
+	w := &batchWriter{}
+	p.AddWriter(w, pencode.Printer{})
+
+	require.NoError(t, p.Run(context.Background()))
+	// The last batch is not full and is written when the pipeline stops
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, w.batches)
+	assert.Zero(t, w.writes)
+}
+
+func TestBatchEncoder(t *testing.T) {
+	testCases := []struct {
+		enc      pencode.Encoder
+		expected []string
+	}{
+		{enc: pencode.NewJSONEncoder(), expected: []string{"1\n2\n3\n", "4\n"}},
+		{enc: pencode.NewJSONArrayEncoder(), expected: []string{"[1,2,3]\n", "[4]\n"}},
+	}
+	for _, tc := range testCases {
+		p := newBatchedPipeline(BatchConfig{MaxItems: 3}, 1, 2, 3, 4)
+		w := &bufferCloser{}
+		p.AddWriter(w, tc.enc)
+
+		require.NoError(t, p.Run(context.Background()))
+		assert.Equal(t, tc.expected, w.writes)
+	}
+}
+
+// This test checks that writers without batch support receive the results of a batch one at a time
+func TestBatchFallback(t *testing.T) {
+	p := newBatchedPipeline(BatchConfig{MaxBytes: 4}, "ab", "cd", "ef")
+	w := &bufferCloser{}
+	p.AddWriter(w, pencode.Printer{})
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []string{"ab", "cd", "ef"}, w.writes)
+}
+
+func TestBatchMaxLatency(t *testing.T) {
+	b := &batcher{cfg: BatchConfig{MaxItems: 10, MaxLatency: time.Second, Size: defaultSize}}
+	start := time.Now()
+
+	assert.Empty(t, b.add(Results{"a", "b"}, start))
+	assert.Nil(t, b.expire(start.Add(time.Second/2)))
+	assert.Equal(t, Results{"a", "b"}, b.expire(start.Add(time.Second)))
+	assert.Nil(t, b.flush())
+}
+
+func TestBatchMaxBytes(t *testing.T) {
+	b := &batcher{cfg: BatchConfig{MaxBytes: 5, Size: defaultSize}}
+
+	full := b.add(Results{"abc", "de", "f", "ghijk", "l"}, time.Now())
+	assert.Equal(t, []Results{{"abc", "de"}, {"f", "ghijk"}}, full)
+	assert.Equal(t, Results{"l"}, b.flush())
+}
+
+// hiccupWriter fails temporarily the first time it writes the given result
+type hiccupWriter struct {
+	bufferCloser
+	on     string
+	failed bool
+}
+
+func (w *hiccupWriter) Write(b []byte) (int, error) {
+	if string(b) == w.on && !w.failed {
+		w.failed = true
+		return 0, NewTemporaryError(errors.New("hiccup"))
+	}
+	return w.bufferCloser.Write(b)
+}
+
+// This test checks that retrying a batch written one result at a time does not write the results again which were
+// already written, both for writers and joined pipelines
+func TestBatchFallbackRetry(t *testing.T) {
+	opts := []Option{WithBatching(BatchConfig{MaxItems: 3}), WithRetry(RetryPolicy{MaxAttempts: 3})}
+
+	p := newQuietPipeline(opts...)
+	p.AddSource(&sliceSource{payloads: []interface{}{"a", "b", "c"}})
+	p.SetProcessor(identity)
+	w := &hiccupWriter{on: "b"}
+	p.AddWriter(w, pencode.Printer{})
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []string{"a", "b", "c"}, w.writes)
+
+	// The join buffer holds a single result and the downstream pipeline holds on to the first result until the
+	// upstream pipeline fails to write to the full buffer
+	full := make(chan struct{})
+	var once sync.Once
+	retry := ErrorPolicyFunc(func(ctx context.Context, f Failure) ErrorAction {
+		once.Do(func() { close(full) })
+		if f.Attempt < 100 {
+			return RetryPayload
+		}
+		return DeadLetterPayload
+	})
+	up := newQuietPipeline(WithBatching(BatchConfig{MaxItems: 3}), WithErrorPolicy(retry),
+		WithRetry(RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}))
+	up.AddSource(&sliceSource{payloads: []interface{}{"a", "b", "c"}})
+	up.SetProcessor(identity)
+	down := newQuietPipeline(WithJoinBuffer(1, ErrorOnFull))
+	down.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if payload == "a" {
+			<-full
+		}
+		return payload, nil
+	}))
+	out := &collectWriter{}
+	down.writers = append(down.writers, out)
+	up.Join(down)
+	require.NoError(t, Run(context.Background(), up, down))
+	assert.Equal(t, []interface{}{"a", "b", "c"}, out.results)
+}
@@ -0,0 +1,311 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// ErrCircuitOpen is the error of the dead letters of results that were not written because the circuit breaker of
+// the writer is open and the writer has neither a fallback nor room in its spill buffer
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of the circuit breaker of a writer
+type BreakerState int
+
+const (
+	// BreakerClosed writes results to the writer
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips the writer and diverts its results to the fallback or spill buffer
+	BreakerOpen
+	// BreakerHalfOpen lets a trial write through to check if the writer has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// CircuitBreaker configures the circuit breaker of a writer
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failed writes that opens the breaker. It defaults to 1.
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive successful trial writes that closes the breaker. It defaults to 1.
+	SuccessThreshold int
+	// Cooldown is how long the breaker stays open before letting a trial write through
+	Cooldown time.Duration
+	// SpillSize is the number of results held while the breaker is open, which are written once it closes again.
+	// It is only used if the writer has no fallback.
+	SpillSize int
+}
+
+// BreakerError reports a change in the state of the circuit breaker of a writer to the error handler
+type BreakerError struct {
+	// Writer identifies the writer
+	Writer string
+	From   BreakerState
+	To     BreakerState
+	// Cause is the message of the write error that changed the state of the breaker, if any. The error itself is
+	// returned by the write and handled separately, so it is not wrapped.
+	Cause string
+	// Rejected is the number of results sent to the dead-letter sink with ErrCircuitOpen while the breaker was open.
+	// They are reported once the breaker leaves the open state instead of one error at a time.
+	Rejected int
+}
+
+func (e *BreakerError) Error() string {
+	msg := fmt.Sprintf("circuit breaker of writer %s changed from %s to %s", e.Writer, e.From, e.To)
+	if e.Rejected > 0 {
+		msg += fmt.Sprintf(" after rejecting %d results", e.Rejected)
+	}
+	if e.Cause != "" {
+		msg += ": " + e.Cause
+	}
+	return msg
+}
+
+// Breaker wraps the writer in a circuit breaker, which skips the writer after it keeps failing and diverts its
+// results to the fallback or spill buffer until it recovers
+func Breaker(cfg CircuitBreaker) WriterOption {
+	return func(w *routedWriter) {
+		if cfg.FailureThreshold < 1 {
+			cfg.FailureThreshold = 1
+		}
+		if cfg.SuccessThreshold < 1 {
+			cfg.SuccessThreshold = 1
+		}
+		w.breaker = &breaker{cfg: cfg}
+	}
+}
+
+// Fallback writes the results to the fallback writer while the circuit breaker of the writer is open
+func Fallback(fw io.WriteCloser, enc pencode.Encoder) WriterOption {
+	return func(w *routedWriter) {
+		w.fallback = &pipeOutput{w: fw, enc: enc}
+	}
+}
+
+// breaker is the circuit breaker of a writer
+type breaker struct {
+	cfg CircuitBreaker
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	opened    time.Time
+	trial     bool
+	spill     []interface{}
+	rejected  int
+}
+
+// allow returns true if a write may go through. An open breaker becomes half-open once the cooldown has passed,
+// which is reported by halfOpened.
+func (b *breaker) allow(now time.Time) (ok, halfOpened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && now.Sub(b.opened) >= b.cfg.Cooldown {
+		b.state, halfOpened = BreakerHalfOpen, true
+	}
+	switch b.state {
+	case BreakerClosed:
+		return true, false
+	case BreakerHalfOpen:
+		// Only a single trial write goes through at a time
+		if b.trial {
+			return false, halfOpened
+		}
+		b.trial = true
+		return true, halfOpened
+	}
+	return false, false
+}
+
+// record counts the outcome of a write and returns the state before and after it
+func (b *breaker) record(err error, now time.Time) (from, to BreakerState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	from = b.state
+	b.trial = false
+	switch {
+	case err == nil && b.state == BreakerHalfOpen:
+		b.successes++
+		if b.successes >= b.cfg.SuccessThreshold {
+			b.state, b.failures, b.successes = BreakerClosed, 0, 0
+		}
+	case err == nil:
+		b.failures = 0
+	case b.state == BreakerHalfOpen:
+		b.state, b.opened, b.successes = BreakerOpen, now, 0
+	default:
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.state, b.opened = BreakerOpen, now
+		}
+	}
+	return from, b.state
+}
+
+// hold adds the results to the spill buffer and returns false if there is no room for them
+func (b *breaker) hold(results []interface{}) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.spill)+len(results) > b.cfg.SpillSize {
+		return false
+	}
+	b.spill = append(b.spill, results...)
+	return true
+}
+
+// reject counts results that could neither be diverted nor held
+func (b *breaker) reject(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejected += n
+}
+
+// takeRejected returns the number of rejected results since it was last called
+func (b *breaker) takeRejected() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rejected := b.rejected
+	b.rejected = 0
+	return rejected
+}
+
+// release empties the spill buffer and returns the results it held
+func (b *breaker) release() []interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spill := b.spill
+	b.spill = nil
+	return spill
+}
+
+// writeTo writes the results read from r to the writer with fn, waiting for the rate limits of the writer and
+// retrying on Temporary errors and as decided by the error policy. Writers with an open circuit breaker are skipped
+// and the results are diverted instead. Errors are tagged with the reader and writer.
+func (p *Pipeline) writeTo(ctx context.Context, r pipeReader, w pipeWriter, results []interface{}, fn func() (int, error)) error {
+	rw, _ := w.(*routedWriter)
+	var b *breaker
+	if rw != nil {
+		b = rw.breaker
+	}
+	if b != nil {
+		ok, halfOpened := b.allow(time.Now())
+		if halfOpened {
+			p.reportBreaker(ctx, w, BreakerOpen, BreakerHalfOpen, nil)
+		}
+		if !ok {
+			return p.divert(r, rw, results)
+		}
+	}
+
+	f := Failure{Stage: StageWrite, Writer: writerName(w), Payload: failedPayload(results)}
+	action, err := p.attempt(ctx, r, f, func() error {
+		_, err := p.writeLimited(ctx, w, len(results), fn)
+		return err
+	})
+	if b == nil {
+		return markSkipped(action, writeError(r, w, err))
+	}
+
+	from, to := b.record(err, time.Now())
+	if from != to {
+		p.reportBreaker(ctx, w, from, to, err)
+	}
+	if to == BreakerClosed && from != BreakerClosed {
+		p.replay(ctx, w, b.release())
+	}
+	return markSkipped(action, writeError(r, w, err))
+}
+
+// divert writes the results skipped by an open circuit breaker to the fallback, or holds them in the spill buffer.
+// Results that do not fit are sent to the dead-letter sink and counted, to be reported with the next change of state
+// of the breaker rather than as an error each.
+func (p *Pipeline) divert(r pipeReader, w *routedWriter, results []interface{}) error {
+	if w.fallback != nil {
+		_, err := (&batchWrite{w: w.fallback, results: results}).write()
+		return err
+	}
+	if w.breaker.hold(results) {
+		return nil
+	}
+	w.breaker.reject(len(results))
+	err := writeError(r, w, ErrCircuitOpen)
+	for _, result := range results {
+		p.deadLetter(StageWrite, r, nil, payloadOf(result), err)
+	}
+	return nil
+}
+
+// replay writes the results held while the circuit breaker was open. Results that fail to write are sent to the
+// dead-letter sink unless the error policy skips them.
+func (p *Pipeline) replay(ctx context.Context, w pipeWriter, spill []interface{}) {
+	for _, result := range spill {
+		result := result
+		err := p.writeTo(ctx, nil, w, []interface{}{result}, func() (int, error) {
+			return w.Write(result)
+		})
+		if err != nil && !skipped(err) {
+			p.deadLetter(StageWrite, nil, nil, payloadOf(result), err)
+		}
+	}
+}
+
+// dropSpill sends the results still held by the circuit breaker of the writer to the dead-letter sink and logs the
+// rejected results that were not reported yet
+func (p *Pipeline) dropSpill(w pipeWriter) {
+	rw, ok := w.(*routedWriter)
+	if !ok || rw.breaker == nil {
+		return
+	}
+	if rejected := rw.breaker.takeRejected(); rejected > 0 {
+		p.log.Println("Rejected", rejected, "results while the circuit breaker of writer", writerName(w), "was open")
+	}
+	spill := rw.breaker.release()
+	if len(spill) == 0 {
+		return
+	}
+	p.log.Println("Dropping", len(spill), "results held by the circuit breaker of writer", writerName(w))
+	for _, result := range spill {
+		p.deadLetter(StageWrite, nil, nil, payloadOf(result), ErrCircuitOpen)
+	}
+}
+
+// reportBreaker reports a change in the state of a circuit breaker to the logger and error handler
+func (p *Pipeline) reportBreaker(ctx context.Context, w pipeWriter, from, to BreakerState, err error) {
+	breakerErr := &BreakerError{Writer: writerName(w), From: from, To: to}
+	if rw, ok := w.(*routedWriter); ok && from == BreakerOpen {
+		breakerErr.Rejected = rw.breaker.takeRejected()
+	}
+	if err != nil {
+		breakerErr.Cause = err.Error()
+	}
+	p.log.Println(breakerErr.Error())
+	p.handleError(ctx, breakerErr)
+}
+
+// writerName returns the name of the io.WriteCloser of the writer, falling back to its type
+func writerName(w pipeWriter) string {
+	if rw, ok := w.(*routedWriter); ok {
+		w = rw.pipeWriter
+	}
+	if out, ok := w.(*pipeOutput); ok {
+		return sourceName(out.w)
+	}
+	return sourceName(w)
+}
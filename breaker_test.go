@@ -0,0 +1,175 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// flakyWriter fails all writes while it is down
+type flakyWriter struct {
+	bufferCloser
+	down bool
+}
+
+func (w *flakyWriter) Write(b []byte) (int, error) {
+	if w.down {
+		return 0, errors.New("writer is down")
+	}
+	return w.bufferCloser.Write(b)
+}
+
+// transitions collects the circuit breaker transitions and the results rejected before them, as reported to the
+// error handler
+type transitions struct {
+	mu       sync.Mutex
+	states   []BreakerState
+	rejected []int
+}
+
+func (tr *transitions) handler(ctx context.Context, err error) error {
+	var breakerErr *BreakerError
+	if errors.As(err, &breakerErr) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		tr.states = append(tr.states, breakerErr.To)
+		tr.rejected = append(tr.rejected, breakerErr.Rejected)
+	}
+	return nil
+}
+
+func TestBreakerFallback(t *testing.T) {
+	var tr transitions
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(tr.handler))
+	p.AddSource(&sliceSource{payloads: []interface{}{"a", "b", "c", "d"}})
+	p.SetProcessor(identity)
+	w := &flakyWriter{down: true}
+	fallback := &bufferCloser{}
+	p.AddWriter(w, pencode.Printer{}, Breaker(CircuitBreaker{FailureThreshold: 2, Cooldown: time.Hour}),
+		Fallback(fallback, pencode.Printer{}))
+	dead := &bufferCloser{}
+	p.SetDeadLetter(dead, pencode.NewJSONEncoder())
+
+	require.NoError(t, p.Run(context.Background()))
+	// The writer is skipped once it failed twice
+	assert.Equal(t, []string{"c", "d"}, fallback.writes)
+	assert.Len(t, dead.writes, 2)
+	assert.Equal(t, []BreakerState{BreakerOpen}, tr.states)
+}
+
+// fatalWriter fails every write with a fatal error
+type fatalWriter struct {
+	bufferCloser
+}
+
+func (w *fatalWriter) Write(b []byte) (int, error) {
+	return 0, NewFatalError(errors.New("writer is gone"))
+}
+
+// This test checks that the write error which opens the breaker is only handled once
+func TestBreakerErrorHandledOnce(t *testing.T) {
+	var tr transitions
+	var fatal int
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(func(ctx context.Context, err error) error {
+		if isFatal(err) {
+			fatal++
+		}
+		return tr.handler(ctx, err)
+	}))
+	p.AddSource(&sliceSource{payloads: []interface{}{"a"}})
+	p.SetProcessor(identity)
+	p.AddWriter(&fatalWriter{}, pencode.Printer{}, Breaker(CircuitBreaker{FailureThreshold: 1, Cooldown: time.Hour}))
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, 1, fatal)
+	assert.Equal(t, []BreakerState{BreakerOpen}, tr.states)
+}
+
+// This test checks that results held while the breaker is open are written once the writer recovers
+func TestBreakerSpill(t *testing.T) {
+	var tr transitions
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(tr.handler))
+	w := &flakyWriter{down: true}
+	p.AddWriter(w, pencode.Printer{}, Breaker(CircuitBreaker{Cooldown: 10 * time.Millisecond, SpillSize: 1}))
+	dead := &bufferCloser{}
+	p.SetDeadLetter(dead, pencode.NewJSONEncoder())
+	ctx := context.Background()
+
+	assert.Error(t, p.write(ctx, nil, "a"))
+	assert.NoError(t, p.write(ctx, nil, "b"))
+	// The spill buffer is full, so the result goes to the dead-letter sink without an error
+	assert.NoError(t, p.write(ctx, nil, "c"))
+	require.Len(t, dead.writes, 1)
+	assert.Contains(t, dead.writes[0], ErrCircuitOpen.Error())
+
+	// The trial write fails and opens the breaker again
+	time.Sleep(10 * time.Millisecond)
+	assert.Error(t, p.write(ctx, nil, "d"))
+
+	w.down = false
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, p.write(ctx, nil, "e"))
+	assert.Equal(t, []string{"e", "b"}, w.writes)
+	assert.Equal(t, []BreakerState{
+		BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed,
+	}, tr.states)
+	assert.Equal(t, []int{0, 1, 0, 0, 0}, tr.rejected)
+}
+
+// This test checks that results rejected while the breaker is open are reported once rather than one at a time
+func TestBreakerRejected(t *testing.T) {
+	var (
+		tr      transitions
+		handled int
+	)
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(func(ctx context.Context, err error) error {
+		handled++
+		return tr.handler(ctx, err)
+	}))
+	p.AddSource(&sliceSource{payloads: numbers(1000)})
+	p.SetProcessor(identity)
+	open := Breaker(CircuitBreaker{FailureThreshold: 3, Cooldown: time.Hour})
+	p.AddWriter(&flakyWriter{down: true}, pencode.Printer{}, open)
+	dead := &bufferCloser{}
+	p.SetDeadLetter(dead, pencode.NewJSONEncoder())
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Len(t, dead.writes, 1000)
+	// The three failed writes and the breaker opening
+	assert.Equal(t, 4, handled)
+	assert.Equal(t, []BreakerState{BreakerOpen}, tr.states)
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	b := &breaker{cfg: CircuitBreaker{FailureThreshold: 1, SuccessThreshold: 2, Cooldown: time.Second}}
+	now := time.Now()
+
+	b.record(errors.New("failed"), now)
+	ok, _ := b.allow(now)
+	assert.False(t, ok)
+
+	ok, halfOpened := b.allow(now.Add(time.Second))
+	assert.True(t, ok)
+	assert.True(t, halfOpened)
+	// Only one trial write goes through at a time
+	ok, _ = b.allow(now.Add(time.Second))
+	assert.False(t, ok)
+
+	from, to := b.record(nil, now.Add(time.Second))
+	assert.Equal(t, BreakerHalfOpen, from)
+	assert.Equal(t, BreakerHalfOpen, to, "two successful trials are needed to close")
+	b.allow(now.Add(time.Second))
+	_, to = b.record(nil, now.Add(time.Second))
+	assert.Equal(t, BreakerClosed, to)
+}
@@ -0,0 +1,87 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// OverflowPolicy describes what a bounded buffer does when an item is added while it is full
+type OverflowPolicy int
+
+const (
+	// Block waits until there is room in the buffer
+	Block OverflowPolicy = iota
+	// DropOldest discards the oldest item in the buffer to make room for the new one
+	DropOldest
+	// DropNewest discards the item being added
+	DropNewest
+	// ErrorOnFull discards the item being added and reports ErrBufferFull to the error handler
+	ErrorOnFull
+)
+
+// ErrBufferFull is returned when an item is added to a full buffer with the ErrorOnFull policy
+var ErrBufferFull = errors.New("pipeline buffer is full")
+
+// bufferConfig is the size and overflow policy of a bounded buffer
+type bufferConfig struct {
+	size   int
+	policy OverflowPolicy
+}
+
+// buffer is a bounded queue of items between two stages of the pipeline
+type buffer struct {
+	items   chan interface{}
+	policy  OverflowPolicy
+	dropped *uint64
+}
+
+// newBuffer creates a new buffer, counting any dropped items in the provided counter
+func newBuffer(cfg bufferConfig, dropped *uint64) *buffer {
+	// Policies other than Block need room for at least one item, otherwise everything would be dropped
+	if cfg.policy != Block && cfg.size < 1 {
+		cfg.size = 1
+	}
+	return &buffer{items: make(chan interface{}, cfg.size), policy: cfg.policy, dropped: dropped}
+}
+
+// push adds an item to the buffer according to the overflow policy
+func (b *buffer) push(ctx context.Context, v interface{}) error {
+	switch b.policy {
+	case DropNewest:
+		select {
+		case b.items <- v:
+		default:
+			atomic.AddUint64(b.dropped, 1)
+		}
+		return nil
+	case DropOldest:
+		for {
+			select {
+			case b.items <- v:
+				return nil
+			default:
+			}
+			select {
+			case <-b.items:
+				atomic.AddUint64(b.dropped, 1)
+			default:
+			}
+		}
+	case ErrorOnFull:
+		select {
+		case b.items <- v:
+			return nil
+		default:
+			atomic.AddUint64(b.dropped, 1)
+			return ErrBufferFull
+		}
+	default:
+		select {
+		case b.items <- v:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package generic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferOverflowPolicies(t *testing.T) {
+	testCases := []struct {
+		policy   OverflowPolicy
+		expected []interface{}
+		errs     int
+	}{
+		{policy: DropOldest, expected: []interface{}{3, 4}},
+		{policy: DropNewest, expected: []interface{}{1, 2}},
+		{policy: ErrorOnFull, expected: []interface{}{1, 2}, errs: 2},
+	}
+
+	for _, tc := range testCases {
+		var dropped uint64
+		b := newBuffer(bufferConfig{size: 2, policy: tc.policy}, &dropped)
+		errs := 0
+		for ii := 1; ii <= 4; ii++ {
+			if err := b.push(context.Background(), ii); err != nil {
+				assert.Equal(t, ErrBufferFull, err)
+				errs++
+			}
+		}
+		close(b.items)
+		var items []interface{}
+		for v := range b.items {
+			items = append(items, v)
+		}
+		assert.Equal(t, tc.expected, items)
+		assert.Equal(t, uint64(2), dropped)
+		assert.Equal(t, tc.errs, errs)
+	}
+}
+
+func TestBufferBlockCanceled(t *testing.T) {
+	var dropped uint64
+	b := newBuffer(bufferConfig{size: 1, policy: Block}, &dropped)
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, b.push(ctx, 1))
+	cancel()
+	assert.Equal(t, context.Canceled, b.push(ctx, 2))
+	assert.Equal(t, uint64(0), dropped)
+}
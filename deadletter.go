@@ -0,0 +1,68 @@
+package generic
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeadLetter is the envelope written to the dead-letter sink for a payload that failed in the pipeline
+type DeadLetter struct {
+	// Stage is the stage of the pipeline in which the payload failed
+	Stage Stage `json:"stage"`
+	// Error is the error message of the failure
+	Error string `json:"error"`
+	// Reader identifies the reader that the payload came from
+	Reader string `json:"reader"`
+	// Raw is the original input the payload was decoded from, if available
+	Raw []byte `json:"raw,omitempty"`
+	// Payload is the decoded payload for processing failures or the result for write failures
+	Payload interface{} `json:"payload,omitempty"`
+	// Timestamp is the time of the failure
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// sink serializes writes to a single output, such as the dead letters or late results of a pipeline
+type sink struct {
+	mu  sync.Mutex
+	out pipeOutput
+}
+
+// deadLetter writes the failed payload to the dead-letter sink if one is set
+func (p *Pipeline) deadLetter(stage Stage, r pipeReader, raw []byte, payload interface{}, err error) {
+	if p.deadLetters == nil {
+		return
+	}
+	letter := DeadLetter{
+		Stage:     stage,
+		Error:     err.Error(),
+		Reader:    readerName(r),
+		Raw:       raw,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}
+
+	p.deadLetters.mu.Lock()
+	defer p.deadLetters.mu.Unlock()
+	if _, err := p.deadLetters.out.Write(letter); err != nil {
+		p.log.Error("Error writing dead letter: %s", err)
+	}
+}
+
+// Namer can be implemented by readers to identify themselves in dead letters and errors
+type Namer interface {
+	Name() string
+}
+
+// readerName returns the name of the reader, falling back to the type of the reader
+func readerName(r pipeReader) string {
+	return sourceName(r)
+}
+
+// sourceName returns the name of the source if it implements Namer, otherwise the type of the source
+func sourceName(src interface{}) string {
+	if n, ok := src.(Namer); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", src)
+}
@@ -0,0 +1,265 @@
+package generic
+
+import (
+	"container/list"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DedupConfig configures the deduplication of the payloads read by a pipeline
+type DedupConfig struct {
+	// ID returns the identifier of a payload. Payloads with the same ID as a payload seen before are dropped.
+	ID func(payload interface{}) string
+	// Window is how long an ID is remembered after it was last seen. Zero remembers IDs until they are evicted.
+	Window time.Duration
+	// MaxEntries is the maximum number of IDs remembered. The least recently seen IDs are evicted first.
+	// Zero does not limit the number of IDs.
+	MaxEntries int
+	// Bloom remembers IDs in Bloom filters instead of storing them, which uses a fixed amount of memory for very
+	// high cardinalities at the cost of occasionally dropping a payload which is not a duplicate
+	Bloom *BloomConfig
+}
+
+// BloomConfig configures the Bloom filters used for deduplication. The filters are rotated every Window, or after
+// Capacity IDs if the DedupConfig has no Window, so that the oldest IDs are forgotten.
+type BloomConfig struct {
+	// Capacity is the number of IDs each filter is sized for
+	Capacity int
+	// FalsePositiveRate is the probability of an ID which has not been seen being reported as a duplicate
+	FalsePositiveRate float64
+}
+
+// WithDedup drops payloads that have the same ID as a payload read before, before they are processed. The IDs of
+// payloads which fail to process or write are forgotten so that their redelivery is processed. The number of payloads
+// dropped is reported by Pipeline.Duplicates.
+func WithDedup(cfg DedupConfig) Option {
+	return func(p *Pipeline) {
+		p.dedup = &dedup{id: cfg.ID, ids: newLRUDeduper(cfg)}
+		if cfg.Bloom != nil {
+			p.dedup.ids = newBloomDeduper(cfg)
+		}
+	}
+}
+
+// dedup drops payloads with IDs that have been seen before
+type dedup struct {
+	id  func(payload interface{}) string
+	ids deduper
+}
+
+// deduper remembers the IDs that have been seen
+type deduper interface {
+	// seen records the ID and returns true if it had been seen before
+	seen(id string, now time.Time) bool
+	// forget forgets the ID, so that it is not seen the next time
+	forget(id string)
+}
+
+// duplicate returns true if the payload of the item has been seen before, counting it as a dropped duplicate
+func (p *Pipeline) duplicate(it item) bool {
+	if p.dedup == nil {
+		return false
+	}
+	if !p.dedup.ids.seen(p.dedup.id(it.payload), time.Now()) {
+		return false
+	}
+	atomic.AddUint64(&p.duplicates, 1)
+	return true
+}
+
+// forget forgets the ID of the payload of an item which failed, so that its redelivery is not dropped
+func (p *Pipeline) forget(it item) {
+	if p.dedup != nil {
+		p.dedup.ids.forget(p.dedup.id(it.payload))
+	}
+}
+
+// Duplicates returns the number of payloads that have been dropped as duplicates
+func (p *Pipeline) Duplicates() uint64 {
+	return atomic.LoadUint64(&p.duplicates)
+}
+
+// lruEntry is an ID and the time it was last seen
+type lruEntry struct {
+	id   string
+	seen time.Time
+}
+
+// lruDeduper remembers the most recently seen IDs
+type lruDeduper struct {
+	window     time.Duration
+	maxEntries int
+
+	mu sync.Mutex
+	// order holds the entries from the most to the least recently seen
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+func newLRUDeduper(cfg DedupConfig) *lruDeduper {
+	return &lruDeduper{window: cfg.Window, maxEntries: cfg.MaxEntries, order: list.New(),
+		ids: make(map[string]*list.Element)}
+}
+
+func (d *lruDeduper) seen(id string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Forget the IDs which have not been seen within the window
+	for d.window > 0 && d.order.Len() > 0 {
+		oldest := d.order.Back()
+		if now.Sub(oldest.Value.(*lruEntry).seen) < d.window {
+			break
+		}
+		d.remove(oldest)
+	}
+
+	if el, ok := d.ids[id]; ok {
+		el.Value.(*lruEntry).seen = now
+		d.order.MoveToFront(el)
+		return true
+	}
+	d.ids[id] = d.order.PushFront(&lruEntry{id: id, seen: now})
+	if d.maxEntries > 0 && d.order.Len() > d.maxEntries {
+		d.remove(d.order.Back())
+	}
+	return false
+}
+
+func (d *lruDeduper) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.ids[id]; ok {
+		d.remove(el)
+	}
+}
+
+func (d *lruDeduper) remove(el *list.Element) {
+	d.order.Remove(el)
+	delete(d.ids, el.Value.(*lruEntry).id)
+}
+
+// bloomDeduper remembers IDs in a current and a previous Bloom filter, which are rotated so that IDs are remembered
+// for at least one and at most two rotations. IDs cannot be removed from a Bloom filter, so forgotten IDs are kept
+// aside until the filters they were added to are reset.
+type bloomDeduper struct {
+	window   time.Duration
+	capacity int
+
+	mu       sync.Mutex
+	current  *bloomFilter
+	previous *bloomFilter
+	rotated  time.Time
+	added    int
+	// forgotten and forgottenPrevious hold the forgotten IDs since the last and the previous rotation
+	forgotten         map[string]struct{}
+	forgottenPrevious map[string]struct{}
+}
+
+func newBloomDeduper(cfg DedupConfig) *bloomDeduper {
+	capacity := cfg.Bloom.Capacity
+	if capacity < 1 {
+		capacity = 1
+	}
+	m, k := bloomSize(capacity, cfg.Bloom.FalsePositiveRate)
+	return &bloomDeduper{window: cfg.Window, capacity: capacity,
+		current: newBloomFilter(m, k), previous: newBloomFilter(m, k), forgotten: make(map[string]struct{})}
+}
+
+func (d *bloomDeduper) seen(id string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.rotated.IsZero() {
+		d.rotated = now
+	}
+	if (d.window > 0 && now.Sub(d.rotated) >= d.window) || (d.window <= 0 && d.added >= d.capacity) {
+		d.current, d.previous = d.previous, d.current
+		d.current.reset()
+		d.rotated, d.added = now, 0
+		d.forgotten, d.forgottenPrevious = make(map[string]struct{}), d.forgotten
+	}
+
+	h1, h2 := bloomHashes(id)
+	_, forgotten := d.forgotten[id]
+	_, forgottenPrevious := d.forgottenPrevious[id]
+	if forgotten || forgottenPrevious {
+		delete(d.forgotten, id)
+		delete(d.forgottenPrevious, id)
+		if !d.current.test(h1, h2) {
+			d.current.add(h1, h2)
+			d.added++
+		}
+		return false
+	}
+	if d.current.test(h1, h2) {
+		return true
+	}
+	d.current.add(h1, h2)
+	d.added++
+	return d.previous.test(h1, h2)
+}
+
+func (d *bloomDeduper) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.forgotten[id] = struct{}{}
+}
+
+// bloomSize returns the number of bits and hash functions of a Bloom filter holding n items with a false positive
+// rate of p
+func bloomSize(n int, p float64) (m, k int) {
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m = int(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k = int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return m, k
+}
+
+// bloomHashes returns the two hashes from which the k hashes of an ID are derived
+func bloomHashes(id string) (uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// bloomFilter is a fixed size Bloom filter using double hashing
+type bloomFilter struct {
+	bits []uint64
+	m, k uint32
+}
+
+func newBloomFilter(m, k int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: uint32(m), k: uint32(k)}
+}
+
+func (f *bloomFilter) add(h1, h2 uint32) {
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) test(h1, h2 uint32) bool {
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+}
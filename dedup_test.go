@@ -0,0 +1,155 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringID(payload interface{}) string {
+	return fmt.Sprint(payload)
+}
+
+func TestDedup(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  DedupConfig
+	}{
+		{name: "lru", cfg: DedupConfig{ID: stringID}},
+		{name: "bloom", cfg: DedupConfig{ID: stringID, Bloom: &BloomConfig{Capacity: 100, FalsePositiveRate: 0.001}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newQuietPipeline(WithDedup(tc.cfg))
+			p.AddSource(&sliceSource{payloads: []interface{}{"a", "b", "a", "c", "b", "a"}})
+			p.SetProcessor(identity)
+			out := &collectWriter{}
+			p.writers = append(p.writers, out)
+
+			require.NoError(t, p.Run(context.Background()))
+			assert.Equal(t, []interface{}{"a", "b", "c"}, out.results)
+			assert.Equal(t, uint64(3), p.Duplicates())
+		})
+	}
+}
+
+// pacedSource returns each of its payloads after waiting for the delay of the payload
+type pacedSource struct {
+	payloads []interface{}
+	delays   []time.Duration
+}
+
+func (s *pacedSource) Read() (interface{}, error) {
+	if len(s.payloads) == 0 {
+		return nil, EOF
+	}
+	time.Sleep(s.delays[0])
+	v := s.payloads[0]
+	s.payloads, s.delays = s.payloads[1:], s.delays[1:]
+	return v, nil
+}
+
+// This test checks that a payload dropped by a rate limit is not treated as a duplicate when it is redelivered
+func TestDedupRateLimitDrop(t *testing.T) {
+	p := newQuietPipeline(WithDedup(DedupConfig{ID: stringID}), WithRateLimit(RateLimit{Rate: 50, Burst: 1, Drop: true}))
+	p.AddSource(&pacedSource{
+		payloads: []interface{}{"a", "b", "b"},
+		delays:   []time.Duration{0, 0, 40 * time.Millisecond},
+	})
+	p.SetProcessor(identity)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{"a", "b"}, out.results)
+	assert.Equal(t, uint64(1), p.Dropped())
+	assert.Equal(t, uint64(0), p.Duplicates())
+}
+
+func TestDedupMaxEntries(t *testing.T) {
+	d := newLRUDeduper(DedupConfig{MaxEntries: 2})
+	now := time.Now()
+
+	assert.False(t, d.seen("a", now))
+	assert.False(t, d.seen("b", now))
+	// Seeing a again makes b the least recently seen ID, which is evicted by c
+	assert.True(t, d.seen("a", now))
+	assert.False(t, d.seen("c", now))
+	assert.False(t, d.seen("b", now))
+	assert.True(t, d.seen("c", now))
+}
+
+func TestDedupWindow(t *testing.T) {
+	d := newLRUDeduper(DedupConfig{Window: time.Minute})
+	start := time.Now()
+
+	assert.False(t, d.seen("a", start))
+	assert.True(t, d.seen("a", start.Add(30*time.Second)))
+	// The window restarts each time the ID is seen
+	assert.True(t, d.seen("a", start.Add(80*time.Second)))
+	assert.False(t, d.seen("a", start.Add(3*time.Minute)))
+	assert.Equal(t, 1, d.order.Len())
+}
+
+// This test checks that Bloom filters forget IDs after two rotations
+func TestDedupBloomRotation(t *testing.T) {
+	d := newBloomDeduper(DedupConfig{Bloom: &BloomConfig{Capacity: 2, FalsePositiveRate: 0.001}})
+	now := time.Now()
+
+	assert.False(t, d.seen("a", now))
+	assert.False(t, d.seen("b", now))
+	// The filter is full and rotated, a is still remembered by the previous filter
+	assert.False(t, d.seen("c", now))
+	assert.True(t, d.seen("a", now))
+	assert.False(t, d.seen("d", now))
+	assert.False(t, d.seen("e", now))
+	assert.False(t, d.seen("b", now))
+
+	// Forgotten IDs are not seen again, even after a rotation
+	d.forget("e")
+	assert.False(t, d.seen("f", now))
+	assert.False(t, d.seen("e", now))
+	assert.True(t, d.seen("e", now))
+}
+
+// This test checks that a payload which failed is not treated as a duplicate when it is redelivered
+func TestDedupRedelivery(t *testing.T) {
+	bloom := &BloomConfig{Capacity: 100, FalsePositiveRate: 0.001}
+	testCases := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "lru", opts: []Option{WithDedup(DedupConfig{ID: stringID})}},
+		{name: "bloom", opts: []Option{WithDedup(DedupConfig{ID: stringID, Bloom: bloom})}},
+		{name: "workers", opts: []Option{WithDedup(DedupConfig{ID: stringID}), WithWorkers(2, Ordered)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var failed int32
+			p := newQuietPipeline(tc.opts...)
+			// b is redelivered after it failed
+			p.AddSource(&pacedSource{
+				payloads: []interface{}{"a", "b", "c", "b"},
+				delays:   []time.Duration{0, 0, 0, 20 * time.Millisecond},
+			})
+			p.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+				if payload == "b" && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+					return nil, errors.New("failed")
+				}
+				return payload, nil
+			}))
+			out := &collectWriter{}
+			p.writers = append(p.writers, out)
+
+			require.NoError(t, p.Run(context.Background()))
+			assert.Equal(t, []interface{}{"a", "c", "b"}, out.results)
+			assert.Equal(t, uint64(0), p.Duplicates())
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package generic
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -8,47 +11,147 @@ import (
 // EOF represents the end of file from a input stream. It is an alias for io.EOF
 var EOF = io.EOF
 
-type pipelineError struct {
-	fatal     bool
-	temporary bool
-	msg       string
+// StageError is an error from a stage of the pipeline, tagged with the identity of the reader or writer involved
+type StageError struct {
+	// Stage is the stage of the pipeline in which the error occurred
+	Stage Stage
+	// Reader identifies the reader of the payload, if known
+	Reader string
+	// Writer identifies the writer for encode and write errors
+	Writer string
+	// Err is the original error
+	Err error
+
+	// skip is set when the error policy skipped the payload
+	skip bool
 }
 
-func (e *pipelineError) FromError(err error) {
-	e.msg = err.Error()
-	if specificErr, ok := err.(Fatal); ok {
-		e.fatal = specificErr.Fatal()
+func (e *StageError) Error() string {
+	var msg strings.Builder
+	_, _ = msg.WriteString(e.Stage.String())
+	if e.Reader != "" {
+		_, _ = fmt.Fprintf(&msg, " from reader %s", e.Reader)
 	}
-	if specificErr, ok := err.(TemporaryError); ok {
-		e.fatal = specificErr.Temporary()
+	if e.Writer != "" {
+		_, _ = fmt.Fprintf(&msg, " to writer %s", e.Writer)
 	}
+	_, _ = fmt.Fprintf(&msg, ": %s", e.Err)
+	return msg.String()
 }
 
-func (e *pipelineError) Error() string {
-	return e.msg
+// Unwrap returns the original error
+func (e *StageError) Unwrap() error {
+	return e.Err
 }
 
-func overallError(errs ...error) *pipelineError {
-	var overall pipelineError
-	var msg strings.Builder
-	_, _ = msg.Write([]byte("errors detected in the pipeline: ["))
-	for ii, err := range errs {
-		var pipelineErr pipelineError
-		pipelineErr.FromError(err)
-		// Combine error message strings
-		_, _ = msg.Write([]byte(pipelineErr.msg))
-		if ii < len(errs)-1 {
-			_, _ = msg.Write([]byte("|"))
+// stageError tags the error with the stage and the identity of the reader and writer, if they are not nil
+func stageError(stage Stage, r pipeReader, w pipeWriter, err error) error {
+	if err == nil {
+		return nil
+	}
+	stageErr := &StageError{Stage: stage, Err: err}
+	if r != nil {
+		stageErr.Reader = readerName(r)
+	}
+	if w != nil {
+		stageErr.Writer = writerName(w)
+	}
+	return stageErr
+}
+
+// writeError tags an error returned by a writer with the write stage and the identity of the reader and writer.
+// Errors which are already tagged, such as encode errors, are given the reader if they lack it. Panics are tagged
+// with the stage that panicked.
+func writeError(r pipeReader, w pipeWriter, err error) error {
+	if err == nil {
+		return nil
+	}
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		if stageErr.Reader == "" && r != nil {
+			stageErr.Reader = readerName(r)
+		}
+		return err
+	}
+	stage := StageWrite
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		stage = panicErr.Stage
+	}
+	return stageError(stage, r, w, err)
+}
+
+// markSkipped marks a write error whose payload was skipped by the error policy
+func markSkipped(action ErrorAction, err error) error {
+	var stageErr *StageError
+	if action == SkipPayload && errors.As(err, &stageErr) {
+		stageErr.skip = true
+	}
+	return err
+}
+
+// skipped returns true if the error policy skipped the payloads of all the errors in err
+func skipped(err error) bool {
+	switch e := err.(type) {
+	case *StageError:
+		return e.skip
+	case MultiError:
+		for _, err := range e {
+			if !skipped(err) {
+				return false
+			}
+		}
+		return len(e) > 0
+	}
+	return false
+}
+
+// MultiError combines the errors of several operations, such as the writes of a result to multiple writers.
+// It unwraps to all of the errors.
+type MultiError []error
+
+// combineErrors returns nil if there are no errors, the error if there is only one, or a MultiError otherwise
+func combineErrors(errs ...error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return MultiError(errs)
+}
+
+func (e MultiError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("errors detected in the pipeline: [%s]", strings.Join(msgs, "|"))
+}
+
+// Unwrap returns all of the errors
+func (e MultiError) Unwrap() []error {
+	return e
+}
+
+// Fatal indicates that at least one of the errors is fatal
+func (e MultiError) Fatal() bool {
+	for _, err := range e {
+		if isFatal(err) {
+			return true
 		}
-		// Determine overall status of flags
-		// If any errors are fatal, the overall error is fatal
-		overall.fatal = overall.fatal || pipelineErr.fatal
-		overall.temporary = overall.temporary && pipelineErr.temporary
 	}
-	_, _ = msg.Write([]byte("]"))
-	overall.msg = msg.String()
+	return false
+}
 
-	return &overall
+// Temporary indicates that all of the errors are temporary, so that retrying may succeed
+func (e MultiError) Temporary() bool {
+	for _, err := range e {
+		if !isTemporary(err) {
+			return false
+		}
+	}
+	return len(e) > 0
 }
 
 // Fatal is an interface describing an error that is fatal to the pipeline
@@ -71,6 +174,11 @@ func (e FatalError) Fatal() bool {
 	return true
 }
 
+// Unwrap returns the underlying error
+func (e FatalError) Unwrap() error {
+	return e.error
+}
+
 // Temporary is an interface describing an error that is temporary and hence retry-able by the pipeline
 type Temporary interface {
 	Temporary() bool
@@ -90,3 +198,68 @@ func NewTemporaryError(error error) TemporaryError {
 func (e TemporaryError) Temporary() bool {
 	return true
 }
+
+// Unwrap returns the underlying error
+func (e TemporaryError) Unwrap() error {
+	return e.error
+}
+
+// ErrShutdownTimeout is the cause of a pipeline stopping when Shutdown could not drain it before its deadline
+var ErrShutdownTimeout = errors.New("pipeline shutdown deadline exceeded")
+
+// isFatal returns true if any error in the chain reports itself as Fatal
+func isFatal(err error) bool {
+	var f Fatal
+	return errors.As(err, &f) && f.Fatal()
+}
+
+// RunError is returned by Pipeline.Run when the pipeline did not stop cleanly after all readers reached EOF
+type RunError struct {
+	// Cause is the fatal error or context error that stopped the pipeline, if any
+	Cause error
+	// CloseErrors are the errors returned when closing the writers of the pipeline
+	CloseErrors []error
+}
+
+func (e *RunError) Error() string {
+	var msg strings.Builder
+	_, _ = msg.WriteString("pipeline stopped")
+	if e.Cause != nil {
+		_, _ = fmt.Fprintf(&msg, ": %s", e.Cause)
+	}
+	for _, err := range e.CloseErrors {
+		_, _ = fmt.Fprintf(&msg, ": error closing writer: %s", err)
+	}
+	return msg.String()
+}
+
+// Unwrap returns the cause of the pipeline stopping and the errors closing its writers
+func (e *RunError) Unwrap() []error {
+	errs := make([]error, 0, len(e.CloseErrors)+1)
+	if e.Cause != nil {
+		errs = append(errs, e.Cause)
+	}
+	return append(errs, e.CloseErrors...)
+}
+
+// canceledBy returns true if the pipeline was stopped only because the group context was canceled while the parent
+// context was not
+func (e *RunError) canceledBy(group, parent context.Context) bool {
+	return len(e.CloseErrors) == 0 && e.Cause == context.Canceled && group.Err() != nil && parent.Err() == nil
+}
+
+// RunErrors contains the errors of each pipeline that failed when engaged by Run
+type RunErrors []error
+
+func (e RunErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d pipelines failed: [%s]", len(e), strings.Join(msgs, "|"))
+}
+
+// Unwrap returns the errors of all the pipelines that failed
+func (e RunErrors) Unwrap() []error {
+	return e
+}
@@ -0,0 +1,113 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/mocks"
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// This test checks that stage errors identify the reader and writer and unwrap to the original error
+func TestStageError(t *testing.T) {
+	origErr := fmt.Errorf("original error")
+	err := stageError(StageWrite, &mocks.PipeReader{}, &mocks.PipeWriter{}, origErr)
+	assert.Equal(t, "write from reader *mocks.PipeReader to writer *mocks.PipeWriter: original error", err.Error())
+	assert.True(t, errors.Is(err, origErr))
+
+	var stageErr *StageError
+	require.True(t, errors.As(err, &stageErr))
+	assert.Equal(t, StageWrite, stageErr.Stage)
+	assert.Nil(t, stageError(StageWrite, nil, nil, nil))
+
+	// Errors which are already tagged are left alone
+	assert.Equal(t, err, writeError(nil, &mocks.PipeWriter{}, err))
+}
+
+// This test checks that multi errors unwrap to all of their errors and classify them as a whole
+func TestMultiError(t *testing.T) {
+	temporary := NewTemporaryError(fmt.Errorf("temporary error"))
+	fatal := NewFatalError(fmt.Errorf("fatal error"))
+	plain := fmt.Errorf("plain error")
+
+	assert.Nil(t, combineErrors())
+	assert.Equal(t, plain, combineErrors(plain))
+
+	err := combineErrors(stageError(StageWrite, nil, nil, temporary), plain)
+	assert.Equal(t, "errors detected in the pipeline: [write: temporary error|plain error]", err.Error())
+	assert.True(t, errors.Is(err, temporary))
+	assert.True(t, errors.Is(err, plain))
+	var stageErr *StageError
+	require.True(t, errors.As(err, &stageErr))
+	assert.Equal(t, StageWrite, stageErr.Stage)
+
+	assert.False(t, isTemporary(err))
+	assert.False(t, isFatal(err))
+	assert.True(t, isTemporary(combineErrors(temporary, temporary)))
+	assert.True(t, isFatal(combineErrors(plain, fatal)))
+}
+
+// This test checks that the error qualities are found through wrapped errors
+func TestErrorQualities(t *testing.T) {
+	origErr := fmt.Errorf("original error")
+	fatal := NewFatalError(origErr)
+	temporary := NewTemporaryError(origErr)
+	assert.True(t, errors.Is(fatal, origErr))
+	assert.True(t, errors.Is(temporary, origErr))
+	assert.True(t, isFatal(stageError(StageProcess, nil, nil, fatal)))
+	assert.True(t, isTemporary(stageError(StageProcess, nil, nil, temporary)))
+
+	runErrs := RunErrors{&RunError{Cause: fatal}}
+	assert.True(t, errors.Is(runErrs, origErr))
+
+	// Errors closing the writers are found as well
+	closeErr := fmt.Errorf("close error")
+	runErr := &RunError{Cause: fatal, CloseErrors: []error{closeErr}}
+	assert.True(t, errors.Is(runErr, origErr))
+	assert.True(t, errors.Is(runErr, closeErr))
+	assert.True(t, errors.Is(&RunError{CloseErrors: []error{closeErr}}, closeErr))
+}
+
+// failEncoder fails to encode every payload
+type failEncoder struct{}
+
+func (failEncoder) Encode(payload interface{}) ([]byte, error) {
+	return nil, errors.New("encode error")
+}
+
+// This test checks that encode and write errors are tagged with both the reader and the writer
+func TestWriteErrorIdentity(t *testing.T) {
+	var handled []error
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(func(ctx context.Context, err error) error {
+		handled = append(handled, err)
+		return err
+	}))
+	p.AddSource(&sliceSource{payloads: []interface{}{"a"}})
+	p.SetProcessor(identity)
+	p.AddWriter(&bufferCloser{}, failEncoder{})
+	p.AddWriter(&flakyWriter{down: true}, pencode.Printer{})
+
+	require.NoError(t, p.Run(context.Background()))
+	require.Len(t, handled, 1)
+	var multiErr MultiError
+	require.True(t, errors.As(handled[0], &multiErr))
+	require.Len(t, multiErr, 2)
+
+	expected := []StageError{
+		{Stage: StageEncode, Reader: "*generic.sliceSource", Writer: "*generic.bufferCloser"},
+		{Stage: StageWrite, Reader: "*generic.sliceSource", Writer: "*generic.flakyWriter"},
+	}
+	for ii, err := range multiErr {
+		var stageErr *StageError
+		require.True(t, errors.As(err, &stageErr))
+		assert.Equal(t, expected[ii].Stage, stageErr.Stage)
+		assert.Equal(t, expected[ii].Reader, stageErr.Reader)
+		assert.Equal(t, expected[ii].Writer, stageErr.Writer)
+	}
+}
@@ -72,7 +72,9 @@ func main() {
 
 		p1.Join(p)
 	}
-	generic.Run(ctx, pipelines...)
+	if err := generic.Run(ctx, pipelines...); err != nil {
+		fmt.Println("Pipeline stopped with error:", err)
+	}
 }
 
 func mustSucceed(err error) {
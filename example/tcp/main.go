@@ -9,6 +9,7 @@ import (
 
 	generic "github.com/lobocv/pipeline"
 	"github.com/lobocv/pipeline/pencode"
+	"github.com/lobocv/pipeline/pipeio"
 )
 
 type exampleJSONProcessor struct{}
@@ -46,11 +47,13 @@ func main() {
 	// Set the processor
 	p.SetProcessor(exampleJSONProcessor{})
 
-	// Add the connection as both a reader and a writer
-	p.AddReader(conn, passthrough, make([]byte, 1000))
-	p.AddWriter(conn, passthrough)
+	// Add the connection as both a reader and a writer. Messages are framed by newlines so that they are not split
+	// or merged by the reads on the stream.
+	lines := pipeio.NewLineDelimited()
+	p.AddMessageSource(pipeio.NewFrameReader(conn, lines), passthrough)
+	p.AddWriter(conn, pipeio.NewFrameEncoder(passthrough, lines))
 	// Add standard out so we can see messages on the pipeline side
-	p.AddWriter(os.Stdout, passthrough)
+	p.AddWriter(os.Stdout, pipeio.NewFrameEncoder(passthrough, lines))
 
 	// Start the pipeline. This is blocking so we can set a timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
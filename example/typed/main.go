@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	generic "github.com/lobocv/pipeline"
+	"github.com/lobocv/pipeline/pipeio"
+	"github.com/lobocv/pipeline/typed"
+)
+
+type person struct {
+	Name string
+}
+
+// Read in the string and interpret it as a name for a person. No type assertion is required.
+func readName(ctx context.Context, payload []byte) (person, error) {
+	return person{Name: string(payload)}, nil
+}
+
+// Print a greeting to the supplied person
+func greet(ctx context.Context, p person) (string, error) {
+	return fmt.Sprintf("Hello there, %s", p.Name), nil
+}
+
+func main() {
+	reader, err := pipeio.NewFileReader("./input.txt", '\n')
+	mustSucceed(err)
+
+	p1 := typed.NewPipeline[[]byte, person](typed.ProcessorFunc[[]byte, person](readName))
+	p1.AddMessageSource(reader, typed.PassThrough{})
+
+	p2 := typed.NewPipeline[person, string](typed.ProcessorFunc[person, string](greet))
+	p2.AddWriter(generic.NopWriteCloser(os.Stdout), typed.Printer[string]{})
+
+	// Joining p2 to a pipeline that does not output a person would not compile
+	typed.Join(p1, p2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	if err := typed.Run(ctx, p1, p2); err != nil {
+		fmt.Println("Pipeline stopped with error:", err)
+	}
+}
+
+func mustSucceed(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -1,8 +1,12 @@
 package generic
 
 import (
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
+	"sync"
+	"time"
 
 	"github.com/lobocv/pipeline/pencode"
 )
@@ -11,6 +15,20 @@ type pipeReader interface {
 	Read() (interface{}, error)
 }
 
+// rawPipeReader is a pipeReader which reads raw input and decodes it in separate steps
+type rawPipeReader interface {
+	pipeReader
+	ReadRaw() ([]byte, error)
+	Decode(raw []byte) (interface{}, error)
+}
+
+// envelopePipeReader is a pipeReader which reads raw messages with their metadata and decodes them in separate steps
+type envelopePipeReader interface {
+	pipeReader
+	ReadMessage() (*Message, error)
+	Decode(raw []byte) (interface{}, error)
+}
+
 type pipeWriter interface {
 	Write(result interface{}) (int, error)
 	Close() error
@@ -22,35 +40,85 @@ type MessageReader interface {
 	Read() ([]byte, error)
 }
 
+// Source is an input to a pipeline which decodes its own payloads, such as pencode.JSONStreamDecoder.
+// It returns EOF once there are no more payloads.
+type Source interface {
+	Read() (interface{}, error)
+}
+
+// ErrPipelineStopped is returned when writing to a joined pipeline which has stopped
+var ErrPipelineStopped = errors.New("joined pipeline has stopped")
+
 // coupler is a struct that allows pipelines to be joined together.
 type coupler struct {
-	data      chan interface{}
+	upstream  *Pipeline
+	buf       *buffer
 	doneWrite chan struct{}
+	closeOnce sync.Once
+	// halted is closed once the downstream pipeline stops reading
+	halted <-chan struct{}
 }
 
-func newCoupler() *coupler {
-	return &coupler{data: make(chan interface{}), doneWrite: make(chan struct{})}
+func newCoupler(cfg bufferConfig, dropped *uint64) *coupler {
+	return &coupler{buf: newBuffer(cfg, dropped), doneWrite: make(chan struct{})}
 }
 
-func (c coupler) Write(result interface{}) (int, error) {
-	c.data <- result
-	return 0, nil
+// Write adds the result to the buffer. Writes blocked by a full buffer fail once the downstream pipeline stops.
+func (c *coupler) Write(result interface{}) (int, error) {
+	return 0, c.buf.push(haltContext{Context: context.Background(), halted: c.halted}, result)
 }
 
-func (c coupler) Read() (interface{}, error) {
+// Read returns the next result in the buffer, or EOF once the upstream pipeline is closed and the buffer is drained
+// or the downstream pipeline stops
+func (c *coupler) Read() (interface{}, error) {
 	select {
-	case v := <-c.data:
+	case v := <-c.buf.items:
 		return v, nil
 	case <-c.doneWrite:
+	case <-c.halted:
 		return nil, EOF
 	}
+	// Drain anything left in the buffer before reporting EOF
+	select {
+	case v := <-c.buf.items:
+		return v, nil
+	default:
+		return nil, EOF
+	}
+}
+
+// watermark returns the watermark of the upstream pipeline
+func (c *coupler) watermark() (time.Time, bool) {
+	if c.upstream == nil {
+		return time.Time{}, false
+	}
+	return c.upstream.watermark()
 }
 
-func (c coupler) Close() error {
-	c.doneWrite <- struct{}{}
+func (c *coupler) Close() error {
+	c.closeOnce.Do(func() { close(c.doneWrite) })
 	return nil
 }
 
+// haltContext is a context which is done once the pipeline it belongs to has halted
+type haltContext struct {
+	context.Context
+	halted <-chan struct{}
+}
+
+func (ctx haltContext) Done() <-chan struct{} {
+	return ctx.halted
+}
+
+func (ctx haltContext) Err() error {
+	select {
+	case <-ctx.halted:
+		return ErrPipelineStopped
+	default:
+		return nil
+	}
+}
+
 // bufferReader contains a io.Reader and a decoder and satisfies the pipeReader interface
 type bufferReader struct {
 	buf []byte
@@ -63,17 +131,33 @@ func newBufferReader(r io.Reader, buf []byte, dec pencode.Decoder) *bufferReader
 }
 
 func (b bufferReader) Read() (interface{}, error) {
-	n, err := b.r.Read(b.buf)
+	raw, err := b.ReadRaw()
 	if err != nil {
 		return nil, err
 	}
+	return b.Decode(raw)
+}
 
-	v, err := b.dec.Decode(b.buf[:n])
+// ReadRaw reads from the io.Reader into the buffer and returns a copy of the bytes read. A copy is made so that
+// the buffer can be reused while the previous payload is still in the pipeline.
+func (b bufferReader) ReadRaw() ([]byte, error) {
+	n, err := b.r.Read(b.buf)
 	if err != nil {
 		return nil, err
 	}
+	raw := make([]byte, n)
+	copy(raw, b.buf[:n])
+	return raw, nil
+}
+
+// Decode decodes the raw bytes
+func (b bufferReader) Decode(raw []byte) (interface{}, error) {
+	return b.dec.Decode(raw)
+}
 
-	return v, nil
+// Name identifies the reader by the underlying io.Reader
+func (b bufferReader) Name() string {
+	return sourceName(b.r)
 }
 
 // messageInput contains a MessageReader and a decoder and satisfies the pipeReader interface
@@ -89,16 +173,75 @@ func newMessageInput(r MessageReader, dec pencode.Decoder) *messageInput {
 // Read reads from the MessageReader and decodes the bytes
 func (p *messageInput) Read() (interface{}, error) {
 	// read the raw input
-	raw, err := p.r.Read()
+	raw, err := p.ReadRaw()
 	if err != nil {
 		return nil, err
 	}
 	// decode the input
-	v, err := p.dec.Decode(raw)
+	return p.Decode(raw)
+}
+
+// ReadRaw reads the raw input from the MessageReader
+func (p *messageInput) ReadRaw() ([]byte, error) {
+	return p.r.Read()
+}
+
+// Decode decodes the raw input
+func (p *messageInput) Decode(raw []byte) (interface{}, error) {
+	return p.dec.Decode(raw)
+}
+
+// Name identifies the reader by the underlying MessageReader
+func (p *messageInput) Name() string {
+	return sourceName(p.r)
+}
+
+// envelopeInput contains an EnvelopeReader and a decoder and satisfies the pipeReader interface
+type envelopeInput struct {
+	r   EnvelopeReader
+	dec pencode.Decoder
+}
+
+func newEnvelopeInput(r EnvelopeReader, dec pencode.Decoder) *envelopeInput {
+	return &envelopeInput{r: r, dec: dec}
+}
+
+// Read reads a message from the EnvelopeReader and decodes its payload
+func (p *envelopeInput) Read() (interface{}, error) {
+	msg, err := p.ReadMessage()
 	if err != nil {
 		return nil, err
 	}
-	return v, nil
+	raw, _ := msg.Payload.([]byte)
+	if msg.Payload, err = p.Decode(raw); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ReadMessage reads the raw message from the EnvelopeReader and fills in the ingest time and source if unset
+func (p *envelopeInput) ReadMessage() (*Message, error) {
+	msg, err := p.r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if msg.IngestTime.IsZero() {
+		msg.IngestTime = time.Now()
+	}
+	if msg.Source == "" {
+		msg.Source = p.Name()
+	}
+	return msg, nil
+}
+
+// Decode decodes the raw payload of a message
+func (p *envelopeInput) Decode(raw []byte) (interface{}, error) {
+	return p.dec.Decode(raw)
+}
+
+// Name identifies the reader by the underlying EnvelopeReader
+func (p *envelopeInput) Name() string {
+	return sourceName(p.r)
 }
 
 type pipeOutput struct {
@@ -106,20 +249,39 @@ type pipeOutput struct {
 	enc pencode.Encoder
 }
 
-// Write encodes the result and writes it to the io.Writer
-func (p *pipeOutput) Write(result interface{}) (int, error) {
-	var n int
+// Write encodes the result and writes it to the io.Writer. If the result is a *Message, its metadata is passed to
+// encoders implementing MessageEncoder and writers implementing MessageWriter. Panics are returned as a *PanicError.
+func (p *pipeOutput) Write(result interface{}) (n int, err error) {
+	stage := StageEncode
+	defer func() {
+		if v := recover(); v != nil {
+			err = newPanicError(stage, result, v)
+		}
+	}()
+
+	var raw []byte
+	msg, isMsg := result.(*Message)
+
 	// encode the results
-	raw, err := p.enc.Encode(result)
+	if enc, ok := p.enc.(MessageEncoder); ok && isMsg {
+		raw, err = enc.EncodeMessage(msg)
+	} else if isMsg {
+		raw, err = p.enc.Encode(msg.Payload)
+	} else {
+		raw, err = p.enc.Encode(result)
+	}
 	if err != nil {
-		return 0, err
+		return 0, &StageError{Stage: StageEncode, Writer: sourceName(p.w), Err: err}
 	}
 
 	// write the results of the payload
-	if n, err = p.w.Write(raw); err != nil {
-		return n, err
+	stage = StageWrite
+	if w, ok := p.w.(MessageWriter); ok && isMsg {
+		n, err = w.WriteMessage(msg, raw)
+	} else {
+		n, err = p.w.Write(raw)
 	}
-	return n, nil
+	return n, err
 }
 
 func (p *pipeOutput) Close() error {
@@ -0,0 +1,98 @@
+package generic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+)
+
+// replicas is the number of points each downstream pipeline has on the consistent hashing ring
+const replicas = 64
+
+// JoinRoundRobin joins the output of this pipeline to the inputs of the provided pipelines, load balancing the
+// results across them in turn so that each result is processed by exactly one of them
+func (p *Pipeline) JoinRoundRobin(outs ...*Pipeline) {
+	rr := &roundRobin{}
+	for _, out := range outs {
+		rr.couplers = append(rr.couplers, p.couple(out))
+	}
+	p.writers = append(p.writers, rr)
+}
+
+// JoinPartitioned joins the output of this pipeline to the inputs of the provided pipelines, partitioning the
+// results by the key returned by the key function. Keys are consistently hashed so that all results with the same
+// key are processed by the same downstream pipeline.
+func (p *Pipeline) JoinPartitioned(key func(result interface{}) string, outs ...*Pipeline) {
+	part := &partitioner{key: key}
+	for ii, out := range outs {
+		part.couplers = append(part.couplers, p.couple(out))
+		for jj := 0; jj < replicas; jj++ {
+			part.ring = append(part.ring, ringPoint{hash: hashKey(fmt.Sprintf("%d-%d", ii, jj)), coupler: ii})
+		}
+	}
+	sort.Slice(part.ring, func(i, j int) bool { return part.ring[i].hash < part.ring[j].hash })
+	p.writers = append(p.writers, part)
+}
+
+// couplers is a group of couplers that are closed together
+type couplers []*coupler
+
+func (cs couplers) Close() error {
+	for _, c := range cs {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// roundRobin is a pipeWriter which writes each result to the next coupler in turn
+type roundRobin struct {
+	couplers
+	next uint64
+}
+
+func (r *roundRobin) Write(result interface{}) (int, error) {
+	if len(r.couplers) == 0 {
+		return 0, nil
+	}
+	n := atomic.AddUint64(&r.next, 1) - 1
+	return r.couplers[n%uint64(len(r.couplers))].Write(result)
+}
+
+// ringPoint is a point on the consistent hashing ring belonging to a coupler
+type ringPoint struct {
+	hash    uint32
+	coupler int
+}
+
+// partitioner is a pipeWriter which writes each result to the coupler owning its key on the consistent hashing ring
+type partitioner struct {
+	couplers
+	key  func(result interface{}) string
+	ring []ringPoint
+}
+
+func (p *partitioner) Write(result interface{}) (int, error) {
+	if len(p.couplers) == 0 {
+		return 0, nil
+	}
+	return p.couplers[p.partition(p.key(payloadOf(result)))].Write(result)
+}
+
+// partition returns the index of the coupler owning the key, which is the first point on the ring at or after the
+// hash of the key
+func (p *partitioner) partition(key string) int {
+	h := hashKey(key)
+	ii := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	if ii == len(p.ring) {
+		ii = 0
+	}
+	return p.ring[ii].coupler
+}
+
+// hashKey hashes the key with 32 bit FNV-1a
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
@@ -0,0 +1,139 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var identity = Map(func(ctx context.Context, payload interface{}) (interface{}, error) {
+	return payload, nil
+})
+
+// newJoinedPipelines creates an upstream pipeline reading n integers and n downstream pipelines collecting them
+func newJoinedPipelines(payloads, n int) (*Pipeline, []*Pipeline, []*collectWriter) {
+	src := &sliceSource{}
+	for ii := 0; ii < payloads; ii++ {
+		src.payloads = append(src.payloads, ii)
+	}
+	up := newQuietPipeline()
+	up.AddSource(src)
+	up.SetProcessor(identity)
+
+	var (
+		downs   []*Pipeline
+		writers []*collectWriter
+	)
+	for ii := 0; ii < n; ii++ {
+		down := newQuietPipeline()
+		down.SetProcessor(identity)
+		w := &collectWriter{}
+		down.writers = append(down.writers, w)
+		downs = append(downs, down)
+		writers = append(writers, w)
+	}
+	return up, downs, writers
+}
+
+func TestJoinRoundRobin(t *testing.T) {
+	up, downs, writers := newJoinedPipelines(30, 3)
+	up.JoinRoundRobin(downs...)
+	require.NoError(t, Run(context.Background(), append(downs, up)...))
+
+	for ii, w := range writers {
+		require.Len(t, w.results, 10)
+		for _, v := range w.results {
+			assert.Equal(t, ii, v.(int)%3)
+		}
+		assert.True(t, w.closed)
+	}
+}
+
+func TestJoinPartitioned(t *testing.T) {
+	up, downs, writers := newJoinedPipelines(100, 3)
+	key := func(result interface{}) string {
+		return fmt.Sprint(result.(int) % 10)
+	}
+	up.JoinPartitioned(key, downs...)
+	require.NoError(t, Run(context.Background(), append(downs, up)...))
+
+	// Every result with the same key is processed by the same downstream pipeline
+	owners := map[string]int{}
+	total := 0
+	for ii, w := range writers {
+		total += len(w.results)
+		for _, v := range w.results {
+			k := key(v)
+			if owner, ok := owners[k]; ok {
+				assert.Equal(t, owner, ii, "key %s was split across pipelines", k)
+			}
+			owners[k] = ii
+		}
+	}
+	assert.Equal(t, 100, total)
+	assert.Len(t, owners, 10)
+}
+
+// newMergedPipelines creates upstream pipelines reading the given payloads and a downstream pipeline collecting them
+func newMergedPipelines(payloads ...[]interface{}) ([]*Pipeline, *Pipeline, *collectWriter) {
+	var ups []*Pipeline
+	for ii, src := range payloads {
+		up := newQuietPipeline()
+		up.AddSource(&sliceSource{payloads: src})
+		// Make the upstream pipelines run at different speeds
+		delay := time.Duration(ii) * time.Millisecond
+		up.SetProcessor(Map(func(ctx context.Context, payload interface{}) (interface{}, error) {
+			time.Sleep(delay)
+			return payload, nil
+		}))
+		ups = append(ups, up)
+	}
+	down := newQuietPipeline()
+	down.SetProcessor(identity)
+	w := &collectWriter{}
+	down.writers = append(down.writers, w)
+	return ups, down, w
+}
+
+func TestMerge(t *testing.T) {
+	ups, down, w := newMergedPipelines([]interface{}{0, 2, 4}, []interface{}{1, 3}, []interface{}{5})
+	down.Merge(ups...)
+	require.NoError(t, Run(context.Background(), append(ups, down)...))
+
+	assert.ElementsMatch(t, []interface{}{0, 1, 2, 3, 4, 5}, w.results)
+	assert.True(t, w.closed)
+}
+
+func TestMergeOrdered(t *testing.T) {
+	at := func(payload interface{}) time.Time {
+		return time.Unix(int64(payload.(int)), 0)
+	}
+	ups, down, w := newMergedPipelines([]interface{}{0, 2, 4, 6}, []interface{}{1, 3}, []interface{}{5, 7, 8})
+	down.MergeOrdered(ByEventTime(at), ups...)
+	require.NoError(t, Run(context.Background(), append(ups, down)...))
+
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8}, w.results)
+	assert.True(t, w.closed)
+}
+
+// This test checks that an upstream pipeline blocked on writing to a joined pipeline stops when the joined pipeline
+// fails
+func TestJoinDownstreamStopped(t *testing.T) {
+	fatalErr := NewFatalError(fmt.Errorf("fatal error from test"))
+	up, downs, _ := newJoinedPipelines(100, 1)
+	downs[0].SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		return nil, fatalErr
+	}))
+	up.Join(downs[0])
+
+	start := time.Now()
+	err := Run(context.Background(), up, downs[0])
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, fatalErr))
+	assert.True(t, time.Since(start) < listenerStopTimeout, "the upstream listener was abandoned")
+}
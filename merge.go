@@ -0,0 +1,134 @@
+package generic
+
+import (
+	"sync"
+	"time"
+)
+
+// Merge joins the outputs of all the upstream pipelines into the input of this pipeline, reading results as soon as
+// they are available from any of them. This pipeline only sees EOF once every upstream pipeline has finished.
+func (p *Pipeline) Merge(ups ...*Pipeline) {
+	p.addMerger(nil, ups)
+}
+
+// MergeOrdered joins the outputs of all the upstream pipelines into the input of this pipeline with a k-way merge,
+// always reading the smallest of the next results of each upstream pipeline according to less. If each upstream
+// pipeline writes its results in order, this pipeline reads them in order. Since the next result of every upstream
+// pipeline is needed to decide which is smallest, a slow upstream pipeline holds back the others.
+// This pipeline only sees EOF once every upstream pipeline has finished.
+func (p *Pipeline) MergeOrdered(less func(a, b interface{}) bool, ups ...*Pipeline) {
+	p.addMerger(less, ups)
+}
+
+// ByEventTime returns a less function for MergeOrdered which orders payloads by the time returned by eventTime
+func ByEventTime(eventTime func(payload interface{}) time.Time) func(a, b interface{}) bool {
+	return func(a, b interface{}) bool {
+		return eventTime(a).Before(eventTime(b))
+	}
+}
+
+func (p *Pipeline) addMerger(less func(a, b interface{}) bool, ups []*Pipeline) {
+	m := &merger{less: less, heads: make([]*mergeHead, len(ups))}
+	for _, up := range ups {
+		c := up.coupler(p)
+		up.writers = append(up.writers, c)
+		m.couplers = append(m.couplers, c)
+	}
+	p.readerLock.Lock()
+	defer p.readerLock.Unlock()
+	p.readers = append(p.readers, m)
+}
+
+// mergeHead is the next value read from an upstream coupler
+type mergeHead struct {
+	v   interface{}
+	eof bool
+}
+
+// merger is a pipeReader which merges the values written to several couplers
+type merger struct {
+	couplers []*coupler
+	less     func(a, b interface{}) bool
+
+	// heads holds the next value of each coupler for ordered merges
+	heads []*mergeHead
+
+	// merged receives the values of all couplers for unordered merges
+	merged    chan interface{}
+	startOnce sync.Once
+}
+
+func (m *merger) Read() (interface{}, error) {
+	if m.less == nil {
+		return m.readAvailable()
+	}
+	return m.readOrdered()
+}
+
+// watermark returns the minimum watermark of the merged pipelines
+func (m *merger) watermark() (time.Time, bool) {
+	var (
+		min   time.Time
+		found bool
+	)
+	for _, c := range m.couplers {
+		if t, ok := c.watermark(); ok && (!found || t.Before(min)) {
+			min, found = t, true
+		}
+	}
+	return min, found
+}
+
+// readAvailable returns the next value written to any of the couplers
+func (m *merger) readAvailable() (interface{}, error) {
+	m.startOnce.Do(func() {
+		m.merged = make(chan interface{})
+		wg := sync.WaitGroup{}
+		for _, c := range m.couplers {
+			wg.Add(1)
+			go func(c *coupler) {
+				defer wg.Done()
+				for {
+					v, err := c.Read()
+					if err != nil {
+						return
+					}
+					m.merged <- v
+				}
+			}(c)
+		}
+		go func() {
+			wg.Wait()
+			close(m.merged)
+		}()
+	})
+
+	v, ok := <-m.merged
+	if !ok {
+		return nil, EOF
+	}
+	return v, nil
+}
+
+// readOrdered returns the smallest of the next values of each coupler which has not reached EOF
+func (m *merger) readOrdered() (interface{}, error) {
+	min := -1
+	for ii, c := range m.couplers {
+		if m.heads[ii] == nil {
+			v, err := c.Read()
+			m.heads[ii] = &mergeHead{v: v, eof: err != nil}
+		}
+		if m.heads[ii].eof {
+			continue
+		}
+		if min < 0 || m.less(payloadOf(m.heads[ii].v), payloadOf(m.heads[min].v)) {
+			min = ii
+		}
+	}
+	if min < 0 {
+		return nil, EOF
+	}
+	v := m.heads[min].v
+	m.heads[min] = nil
+	return v, nil
+}
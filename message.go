@@ -0,0 +1,76 @@
+package generic
+
+import (
+	"context"
+	"time"
+)
+
+// Message is an envelope around a payload which carries metadata through the pipeline, including across joined
+// pipelines
+type Message struct {
+	// Payload is the raw []byte when returned from an EnvelopeReader, the decoded payload during processing and
+	// the result of processing when written
+	Payload interface{}
+	// Key is an optional key identifying the message, such as a Kafka message key
+	Key []byte
+	// Headers are arbitrary metadata such as trace IDs or source offsets
+	Headers map[string]string
+	// EventTime is the time at which the event described by the message occurred
+	EventTime time.Time
+	// IngestTime is the time at which the message was read by the pipeline
+	IngestTime time.Time
+	// Source identifies where the message came from
+	Source string
+}
+
+// clone returns a copy of the message with its own headers, so that pipelines sharing a message can change it
+// independently
+func (m *Message) clone() *Message {
+	out := *m
+	if m.Headers != nil {
+		out.Headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			out.Headers[k] = v
+		}
+	}
+	return &out
+}
+
+// EnvelopeReader is a MessageReader which returns messages along with their metadata. The Payload of the returned
+// Message should contain the raw []byte to be decoded.
+type EnvelopeReader interface {
+	ReadMessage() (*Message, error)
+}
+
+// MessageEncoder can be implemented by encoders that need access to the message metadata.
+// The Payload of the message is the result of processing.
+type MessageEncoder interface {
+	EncodeMessage(msg *Message) ([]byte, error)
+}
+
+// MessageWriter can be implemented by io.WriteClosers that need access to the message metadata, for instance to
+// forward headers to a message queue.
+type MessageWriter interface {
+	WriteMessage(msg *Message, b []byte) (int, error)
+}
+
+type messageContextKey struct{}
+
+// MessageFromContext returns the message being processed, or nil if the payload was not read with its metadata
+func MessageFromContext(ctx context.Context) *Message {
+	msg, _ := ctx.Value(messageContextKey{}).(*Message)
+	return msg
+}
+
+// withMessage returns a copy of the context carrying the message
+func withMessage(ctx context.Context, msg *Message) context.Context {
+	return context.WithValue(ctx, messageContextKey{}, msg)
+}
+
+// payloadOf returns the payload of a value written by a pipeline, unwrapping it from its message if necessary
+func payloadOf(v interface{}) interface{} {
+	if msg, ok := v.(*Message); ok {
+		return msg.Payload
+	}
+	return v
+}
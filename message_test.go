@@ -0,0 +1,118 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+type envelopeSlice struct {
+	msgs []*Message
+}
+
+func (r *envelopeSlice) ReadMessage() (*Message, error) {
+	if len(r.msgs) == 0 {
+		return nil, EOF
+	}
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return msg, nil
+}
+
+type headerWriter struct {
+	msgs []Message
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	return 0, fmt.Errorf("expected WriteMessage to be called")
+}
+
+func (w *headerWriter) WriteMessage(msg *Message, b []byte) (int, error) {
+	out := *msg
+	out.Payload = string(b)
+	w.msgs = append(w.msgs, out)
+	return len(b), nil
+}
+
+func (w *headerWriter) Close() error {
+	return nil
+}
+
+// This test checks that message metadata is available to processors and writers across joined pipelines
+func TestMessageEnvelope(t *testing.T) {
+	eventTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	reader := &envelopeSlice{msgs: []*Message{
+		{Payload: []byte("a"), Key: []byte("k1"), Headers: map[string]string{"trace": "1"}, EventTime: eventTime},
+		{Payload: []byte("b"), Key: []byte("k2"), Headers: map[string]string{"trace": "2"}, EventTime: eventTime},
+	}}
+
+	p1 := newQuietPipeline()
+	p1.AddEnvelopeSource(reader, pencode.PassThrough{})
+	p1.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		msg := MessageFromContext(ctx)
+		msg.Headers["seen"] = "p1"
+		return string(msg.Key) + "=" + string(payload.([]byte)), nil
+	}))
+
+	p2 := newQuietPipeline()
+	p2.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		return payload.(string) + "!", nil
+	}))
+	w := &headerWriter{}
+	p2.AddWriter(w, pencode.Printer{})
+	p1.Join(p2)
+
+	Run(context.Background(), p1, p2)
+
+	require.Len(t, w.msgs, 2)
+	for ii, msg := range w.msgs {
+		assert.Equal(t, fmt.Sprintf("k%d=%s!", ii+1, string(rune('a'+ii))), msg.Payload)
+		assert.Equal(t, fmt.Sprint(ii+1), msg.Headers["trace"])
+		assert.Equal(t, "p1", msg.Headers["seen"])
+		assert.Equal(t, eventTime, msg.EventTime)
+		assert.False(t, msg.IngestTime.IsZero())
+		assert.Equal(t, "*generic.envelopeSlice", msg.Source)
+	}
+}
+
+// This test checks that pipelines joined to the same upstream pipeline each get their own copy of the message
+func TestMessageJoinedCopies(t *testing.T) {
+	var msgs []*Message
+	for ii := 0; ii < 50; ii++ {
+		msgs = append(msgs, &Message{Payload: []byte(fmt.Sprint(ii)), Headers: map[string]string{"trace": fmt.Sprint(ii)}})
+	}
+	up := newQuietPipeline()
+	up.AddEnvelopeSource(&envelopeSlice{msgs: msgs}, pencode.PassThrough{})
+	up.SetProcessor(identity)
+
+	var writers []*headerWriter
+	pipelines := []*Pipeline{up}
+	for _, name := range []string{"down1", "down2"} {
+		name := name
+		down := newQuietPipeline()
+		down.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+			MessageFromContext(ctx).Headers["seen"] = name
+			return payload, nil
+		}))
+		w := &headerWriter{}
+		down.AddWriter(w, pencode.Printer{})
+		up.Join(down)
+		writers = append(writers, w)
+		pipelines = append(pipelines, down)
+	}
+
+	require.NoError(t, Run(context.Background(), pipelines...))
+	for ii, w := range writers {
+		require.Len(t, w.msgs, 50)
+		for jj, msg := range w.msgs {
+			assert.Equal(t, fmt.Sprint(jj), msg.Headers["trace"])
+			assert.Equal(t, fmt.Sprintf("down%d", ii+1), msg.Headers["seen"])
+		}
+	}
+}
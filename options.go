@@ -0,0 +1,56 @@
+package generic
+
+// Option configures optional behaviour of a Pipeline
+type Option func(p *Pipeline)
+
+// Ordering describes whether results processed concurrently are written in the order their payloads were read
+type Ordering int
+
+const (
+	// Ordered writes results in the same order that their payloads were read
+	Ordered Ordering = iota
+	// Unordered writes results as soon as they are ready
+	Unordered
+)
+
+// WithWorkers sets the number of goroutines that process payloads concurrently for each reader of the pipeline.
+// The ordering determines whether the results are written in input order or as soon as they are ready.
+func WithWorkers(n int, order Ordering) Option {
+	return func(p *Pipeline) {
+		p.workers = n
+		p.ordering = order
+	}
+}
+
+// WithStageBuffers places bounded buffers of the given size between the read, process and write stages of each
+// reader. The overflow policy determines what happens when a stage produces items faster than the next consumes them.
+func WithStageBuffers(size int, policy OverflowPolicy) Option {
+	return func(p *Pipeline) {
+		p.stageBuffer = bufferConfig{size: size, policy: policy}
+	}
+}
+
+// WithJoinBuffer sets the size and overflow policy of the buffer on each coupler that feeds this pipeline from
+// an upstream pipeline through Join. Buffering the coupler prevents a slow pipeline from blocking its siblings.
+func WithJoinBuffer(size int, policy OverflowPolicy) Option {
+	return func(p *Pipeline) {
+		p.joinBuffer = bufferConfig{size: size, policy: policy}
+	}
+}
+
+// WithRetry sets the retry policy used by all stages of the pipeline for Temporary errors
+func WithRetry(policy RetryPolicy) Option {
+	return func(p *Pipeline) {
+		p.retry = policy
+	}
+}
+
+// WithStageRetry overrides the retry policy used for Temporary errors in a specific stage of the pipeline
+func WithStageRetry(stage Stage, policy RetryPolicy) Option {
+	return func(p *Pipeline) {
+		if p.stageRetry == nil {
+			p.stageRetry = make(map[Stage]RetryPolicy)
+		}
+		p.stageRetry[stage] = policy
+	}
+}
@@ -0,0 +1,83 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicPolicy determines whether a panic in a stage of the pipeline stops the pipeline
+type PanicPolicy int
+
+const (
+	// RecoverPanics reports panics to the error handler and continues with the next payload
+	RecoverPanics PanicPolicy = iota
+	// StopOnPanic reports panics to the error handler as Fatal errors, which stop the pipeline
+	StopOnPanic
+)
+
+// WithPanicPolicy sets whether panics in processors, decoders and encoders stop the pipeline. Panics are always
+// recovered and reported to the error handler as a *PanicError.
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(p *Pipeline) {
+		p.panicPolicy = policy
+	}
+}
+
+// PanicError is the error of a stage of the pipeline which panicked. It is Fatal if the pipeline stops on panics.
+type PanicError struct {
+	// Stage is the stage of the pipeline which panicked
+	Stage Stage
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the goroutine which panicked
+	Stack []byte
+	// Payload is the raw input, payload or result that was being handled when the stage panicked
+	Payload interface{}
+
+	fatal bool
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic during %s: %v", e.Stage, e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Fatal indicates whether the panic stops the pipeline
+func (e *PanicError) Fatal() bool {
+	return e.fatal
+}
+
+// newPanicError creates a *PanicError for the value recovered from a panic, capturing the current stack
+func newPanicError(stage Stage, payload, v interface{}) *PanicError {
+	return &PanicError{Stage: stage, Value: v, Stack: debug.Stack(), Payload: payload}
+}
+
+// recoverPanic recovers from a panic in the stage and returns it in err as a *PanicError. It must be deferred.
+func recoverPanic(stage Stage, payload interface{}, err *error) {
+	if v := recover(); v != nil {
+		*err = newPanicError(stage, payload, v)
+	}
+}
+
+// callProcessor passes the payload to the processor, recovering from panics
+func (p *Pipeline) callProcessor(ctx context.Context, payload interface{}) (result interface{}, err error) {
+	defer recoverPanic(StageProcess, payload, &err)
+	return p.proc.Process(ctx, payload)
+}
+
+// classifyPanic makes a *PanicError in the chain of err Fatal if the pipeline stops on panics
+func (p *Pipeline) classifyPanic(err error) error {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		panicErr.fatal = p.panicPolicy == StopOnPanic
+		p.log.Error("Recovered from panic: %s\n%s", panicErr, panicErr.Stack)
+	}
+	return err
+}
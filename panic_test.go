@@ -0,0 +1,98 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// panicCollector collects the panics reported to the error handler
+type panicCollector struct {
+	panics []*PanicError
+}
+
+func (c *panicCollector) handler(ctx context.Context, err error) error {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		c.panics = append(c.panics, panicErr)
+	}
+	return err
+}
+
+// upper is a processor that panics on payloads which are not strings
+var upper = ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+	return strings.ToUpper(payload.(string)), nil
+})
+
+func TestProcessorPanic(t *testing.T) {
+	var c panicCollector
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(c.handler))
+	p.AddSource(&sliceSource{payloads: []interface{}{"a", 2, "c"}})
+	p.SetProcessor(upper)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{"A", "C"}, out.results)
+	require.Len(t, c.panics, 1)
+	assert.Equal(t, StageProcess, c.panics[0].Stage)
+	assert.Equal(t, 2, c.panics[0].Payload)
+	assert.Contains(t, string(c.panics[0].Stack), "panic_test.go")
+	assert.False(t, c.panics[0].Fatal())
+}
+
+func TestStopOnPanic(t *testing.T) {
+	p := newQuietPipeline(WithPanicPolicy(StopOnPanic))
+	p.AddSource(&sliceSource{payloads: []interface{}{1, "b"}})
+	p.SetProcessor(upper)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	err := p.Run(context.Background())
+	require.Error(t, err)
+	assert.True(t, isFatal(err))
+	assert.Empty(t, out.results)
+}
+
+type panicCodec struct{}
+
+func (panicCodec) Decode(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		panic("empty payload")
+	}
+	return string(b), nil
+}
+
+func (panicCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(v.(string)), nil
+}
+
+// This test checks that panics in decoders and encoders are recovered and reported with their stage
+func TestCodecPanic(t *testing.T) {
+	var c panicCollector
+	p := newQuietPipeline()
+	p.SetErrorHandler(errorHandlerFunc(c.handler))
+	p.AddEnvelopeSource(&envelopeSlice{msgs: []*Message{
+		{Payload: []byte("a")}, {Payload: []byte{}}, {Payload: []byte("c")},
+	}}, panicCodec{})
+	p.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if payload == "c" {
+			return 3, nil
+		}
+		return payload, nil
+	}))
+	out := &bufferCloser{}
+	p.AddWriter(out, panicCodec{})
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []string{"a"}, out.writes)
+	require.Len(t, c.panics, 2)
+	assert.Equal(t, StageDecode, c.panics[0].Stage)
+	assert.Equal(t, []byte{}, c.panics[0].Payload)
+	assert.Equal(t, StageEncode, c.panics[1].Stage)
+}
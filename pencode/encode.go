@@ -5,6 +5,12 @@ type Encoder interface {
 	Encode(v interface{}) ([]byte, error)
 }
 
+// BatchEncoder is the interface for encoding a batch of output payloads into a single []byte in order to be written
+// at once
+type BatchEncoder interface {
+	EncodeBatch(vs []interface{}) ([]byte, error)
+}
+
 // Decoder is the interface for decoding input payloads from []byte into structures to be used in processing
 type Decoder interface {
 	Decode(b []byte) (interface{}, error)
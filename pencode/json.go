@@ -3,6 +3,8 @@ package pencode
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 )
 
 // JSONDecoder decodes the byte payload into the given struct via the allocator
@@ -43,3 +45,113 @@ func (d JSONEncoder) Encode(v interface{}) ([]byte, error) {
 	err := enc.Encode(v)
 	return writer.Bytes(), err
 }
+
+// EncodeBatch encodes the structs into a block of newline-delimited json documents (NDJSON)
+func (d JSONEncoder) EncodeBatch(vs []interface{}) ([]byte, error) {
+	writer := bytes.Buffer{}
+	enc := json.NewEncoder(&writer)
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	return writer.Bytes(), nil
+}
+
+// JSONArrayEncoder encodes structs into json payloads and batches of structs into a single json array
+type JSONArrayEncoder struct {
+	JSONEncoder
+}
+
+// NewJSONArrayEncoder creates a new JSONArrayEncoder
+func NewJSONArrayEncoder() *JSONArrayEncoder {
+	return &JSONArrayEncoder{}
+}
+
+// EncodeBatch encodes the structs into a single json array
+func (d JSONArrayEncoder) EncodeBatch(vs []interface{}) ([]byte, error) {
+	if vs == nil {
+		vs = []interface{}{}
+	}
+	b, err := json.Marshal(vs)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// JSONStreamDecoder decodes a stream of JSON documents from an io.Reader into allocated structs one at a time,
+// without loading the whole stream into memory. The documents may be newline-delimited (NDJSON), concatenated with
+// any whitespace in between, or the elements of a single top-level array. It satisfies the generic.Source interface.
+type JSONStreamDecoder struct {
+	Strict   bool
+	allocate allocator
+	dec      *json.Decoder
+	array    bool
+	started  bool
+	err      error
+}
+
+// NewJSONStreamDecoder creates a decoder for a stream of newline-delimited or concatenated JSON documents
+func NewJSONStreamDecoder(r io.Reader, alloc allocator, strict bool) *JSONStreamDecoder {
+	return &JSONStreamDecoder{allocate: alloc, Strict: strict, dec: json.NewDecoder(r)}
+}
+
+// NewJSONArrayStreamDecoder creates a decoder for the elements of a stream containing a single top-level JSON array
+func NewJSONArrayStreamDecoder(r io.Reader, alloc allocator, strict bool) *JSONStreamDecoder {
+	d := NewJSONStreamDecoder(r, alloc, strict)
+	d.array = true
+	return d
+}
+
+// Read decodes the next document in the stream into a newly allocated struct. It returns io.EOF at the end of the
+// stream. If the stream is malformed, the error is returned once and all following reads return io.EOF since the
+// position of the next document cannot be determined.
+func (d *JSONStreamDecoder) Read() (interface{}, error) {
+	if d.err != nil {
+		return nil, io.EOF
+	}
+
+	if d.array {
+		if !d.started {
+			d.started = true
+			if err := d.expectDelim('['); err != nil {
+				return nil, err
+			}
+		}
+		if !d.dec.More() {
+			if err := d.expectDelim(']'); err != nil {
+				return nil, err
+			}
+			d.err = io.EOF
+			return nil, io.EOF
+		}
+	}
+
+	// The document is read as a whole first, so that the stream can continue from the next one if the document does
+	// not fit the struct
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		if err == io.EOF && d.array {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+		return nil, err
+	}
+	return JSONDecoder{Strict: d.Strict, allocate: d.allocate}.Decode(raw)
+}
+
+// expectDelim reads the next token and checks that it is the expected delimiter
+func (d *JSONStreamDecoder) expectDelim(delim json.Delim) error {
+	tok, err := d.dec.Token()
+	if err == nil && tok != delim {
+		err = fmt.Errorf("expected %q in JSON stream but got %v", delim, tok)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		d.err = err
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package pencode
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type person struct {
+	Name string `json:"name"`
+}
+
+func allocPerson() interface{} {
+	return new(person)
+}
+
+func readAll(t *testing.T, d *JSONStreamDecoder) (names []string, errs []error) {
+	for {
+		v, err := d.Read()
+		if err == io.EOF {
+			return names, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, v.(*person).Name)
+	}
+}
+
+func TestJSONStreamDecoder(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		array bool
+	}{
+		{name: "ndjson", input: "{\"name\": \"a\"}\n{\"name\": \"b\"}\n{\"name\": \"c\"}\n"},
+		{name: "concatenated", input: "{\"name\": \"a\"}{\"name\": \"b\"}  \t{\n\"name\": \"c\"}"},
+		{name: "array", input: "[{\"name\": \"a\"},\n {\"name\": \"b\"}, {\"name\": \"c\"}]\n", array: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewJSONStreamDecoder(strings.NewReader(tc.input), allocPerson, true)
+			if tc.array {
+				d = NewJSONArrayStreamDecoder(strings.NewReader(tc.input), allocPerson, true)
+			}
+			names, errs := readAll(t, d)
+			assert.Empty(t, errs)
+			assert.Equal(t, []string{"a", "b", "c"}, names)
+		})
+	}
+}
+
+func TestJSONStreamDecoderErrors(t *testing.T) {
+	// Unknown fields are rejected in strict mode but the stream continues
+	d := NewJSONStreamDecoder(strings.NewReader(`{"name": "a", "age": 1} {"name": "b"}`), allocPerson, true)
+	names, errs := readAll(t, d)
+	assert.Equal(t, []string{"b"}, names)
+	require.Len(t, errs, 1)
+
+	// Documents which do not fit the struct are skipped in arrays as well
+	d = NewJSONArrayStreamDecoder(strings.NewReader(`[{"name": "a", "age": 1}, {"name": 2}, {"name": "b"}]`),
+		allocPerson, true)
+	names, errs = readAll(t, d)
+	assert.Equal(t, []string{"b"}, names)
+	require.Len(t, errs, 2)
+
+	d = NewJSONStreamDecoder(strings.NewReader(`{"name": "a", "age": 1} {"name": "b"}`), allocPerson, false)
+	names, errs = readAll(t, d)
+	assert.Equal(t, []string{"a", "b"}, names)
+	assert.Empty(t, errs)
+
+	// Malformed streams end after reporting the error once
+	d = NewJSONStreamDecoder(strings.NewReader(`{"name": "a"} {"name": `), allocPerson, false)
+	names, errs = readAll(t, d)
+	assert.Equal(t, []string{"a"}, names)
+	assert.Equal(t, []error{io.ErrUnexpectedEOF}, errs)
+
+	d = NewJSONArrayStreamDecoder(strings.NewReader(`[{"name": "a"}`), allocPerson, false)
+	names, errs = readAll(t, d)
+	assert.Equal(t, []string{"a"}, names)
+	assert.Len(t, errs, 1)
+}
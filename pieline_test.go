@@ -2,12 +2,22 @@ package generic
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/lobocv/pipeline/mocks"
+	"github.com/lobocv/pipeline/pencode"
 )
 
 var pCount int
@@ -96,7 +106,7 @@ func generatePayloads(n int) (payloads []Payload) {
 func (t *PipelineSuite) TestSingleReaderWriter() {
 	mockReader := t.mockReaders[0]
 	mockWriter := t.mockWriters[0]
-	ctx := context.Background()
+	ctx, derived := newRunContext()
 	numPayloads := 3
 	payloads := []P{}
 	for ii := 0; ii < numPayloads; ii++ {
@@ -105,7 +115,7 @@ func (t *PipelineSuite) TestSingleReaderWriter() {
 		mockReader.On("Read").Return(p, nil).Once()
 		result := p
 		result.processed = true
-		t.mockProc.On("Process", ctx, p).Return(result, nil).Once()
+		t.mockProc.On("Process", derived, p).Return(result, nil).Once()
 		mockWriter.On("Write", result).Return(0, nil).Once()
 	}
 	t.setMockEOF()
@@ -123,13 +133,13 @@ func (t *PipelineSuite) TestMultiReaderWriter() {
 	t.Len(t.pipeline.readers, 3)
 	t.Len(t.pipeline.writers, 3)
 
-	ctx := context.Background()
+	ctx, derived := newRunContext()
 	for _, payload := range generatePayloads(3) {
 
 		for _, m := range t.mockReaders {
 			m.On("Read").Return(payload.raw, nil).Once()
 
-			t.mockProc.On("Process", ctx, payload.raw).Return(payload.proc, nil).Once()
+			t.mockProc.On("Process", derived, payload.raw).Return(payload.proc, nil).Once()
 
 			for _, m := range t.mockWriters {
 				m.On("Write", payload.proc).Return(0, nil).Once()
@@ -144,7 +154,7 @@ func (t *PipelineSuite) TestMultiReaderWriter() {
 func (t *PipelineSuite) TestPipelineErrorHandling() {
 	t.addMockWriter()
 
-	ctx := context.Background()
+	ctx, derived := newRunContext()
 	mockReader := t.mockReaders[0]
 
 	type writeError struct {
@@ -170,18 +180,20 @@ func (t *PipelineSuite) TestPipelineErrorHandling() {
 		payload := generatePayloads(1)[0]
 		if tc.readErr != nil {
 			mockReader.On("Read").Return(nil, tc.readErr).Once()
-			t.mockErrHandler.On("HandleError", ctx, tc.readErr).Return(tc.readErr)
+			readErr := stageError(StageRead, mockReader, nil, tc.readErr)
+			t.mockErrHandler.On("HandleError", derived, readErr).Return(readErr)
 			continue
 		} else {
 			mockReader.On("Read").Return(payload.raw, nil).Once()
 		}
 
 		if tc.procErr != nil && tc.readErr == nil {
-			t.mockProc.On("Process", ctx, payload.raw).Return(nil, tc.procErr).Once()
-			t.mockErrHandler.On("HandleError", ctx, tc.procErr).Return(tc.procErr)
+			t.mockProc.On("Process", derived, payload.raw).Return(nil, tc.procErr).Once()
+			procErr := stageError(StageProcess, mockReader, nil, tc.procErr)
+			t.mockErrHandler.On("HandleError", derived, procErr).Return(procErr)
 			continue
 		} else {
-			t.mockProc.On("Process", ctx, payload.raw).Return(payload.proc, nil).Once()
+			t.mockProc.On("Process", derived, payload.raw).Return(payload.proc, nil).Once()
 		}
 
 		for _, mockWriter := range t.mockWriters {
@@ -202,10 +214,10 @@ func (t *PipelineSuite) TestPipelineErrorHandling() {
 		if len(tc.writeErr) > 0 {
 			var errors []error
 			for _, writerErr := range tc.writeErr {
-				errors = append(errors, writerErr.err)
+				errors = append(errors, stageError(StageWrite, mockReader, writerErr.writer, writerErr.err))
 			}
-			err := overallError(errors...)
-			t.mockErrHandler.On("HandleError", ctx, err).Return(err)
+			err := combineErrors(errors...)
+			t.mockErrHandler.On("HandleError", derived, err).Return(err)
 		}
 
 	}
@@ -214,6 +226,167 @@ func (t *PipelineSuite) TestPipelineErrorHandling() {
 	t.pipeline.Run(ctx)
 }
 
+// This test checks that results processed by a pool of workers are written in the order they were read
+func (t *PipelineSuite) TestWorkersOrdered() {
+	t.pipeline.workers = 4
+	t.pipeline.ordering = Ordered
+	t.checkWorkers(true)
+}
+
+// This test checks that results processed by a pool of workers are all written when ordering is not required
+func (t *PipelineSuite) TestWorkersUnordered() {
+	t.pipeline.workers = 4
+	t.pipeline.ordering = Unordered
+	t.checkWorkers(false)
+}
+
+func (t *PipelineSuite) checkWorkers(ordered bool) {
+	ctx, derived := newRunContext()
+	mockReader := t.mockReaders[0]
+	mockWriter := t.mockWriters[0]
+	payloads := generatePayloads(8)
+
+	var (
+		expected []P
+		written  []P
+		mu       sync.Mutex
+	)
+	for ii, payload := range payloads {
+		mockReader.On("Read").Return(payload.raw, nil).Once()
+		// Earlier payloads take longer to process so that they finish out of order
+		t.mockProc.On("Process", derived, payload.raw).After(time.Duration(len(payloads)-ii)*5*time.Millisecond).Return(payload.proc, nil).Once()
+		mockWriter.On("Write", payload.proc).Return(0, nil).Run(func(args mock.Arguments) {
+			mu.Lock()
+			defer mu.Unlock()
+			written = append(written, args.Get(0).(P))
+		}).Once()
+		expected = append(expected, payload.proc)
+	}
+	t.setMockEOF()
+	t.pipeline.Run(ctx)
+
+	if ordered {
+		t.Equal(expected, written)
+	} else {
+		t.ElementsMatch(expected, written)
+	}
+}
+
+// This test checks that stages returning Temporary errors are retried according to the retry policy
+func (t *PipelineSuite) TestRetryTemporary() {
+	t.pipeline.retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	t.pipeline.stageRetry = map[Stage]RetryPolicy{StageWrite: {MaxAttempts: 2}}
+
+	ctx, derived := newRunContext()
+	mockReader := t.mockReaders[0]
+	mockWriter := t.mockWriters[0]
+	payloads := generatePayloads(2)
+
+	// The first payload succeeds after two temporary processing errors and one temporary write error
+	tempErr := NewTemporaryError(fmt.Errorf("temporary error from test"))
+	mockReader.On("Read").Return(payloads[0].raw, nil).Once()
+	t.mockProc.On("Process", derived, payloads[0].raw).Return(nil, tempErr).Twice()
+	t.mockProc.On("Process", derived, payloads[0].raw).Return(payloads[0].proc, nil).Once()
+	mockWriter.On("Write", payloads[0].proc).Return(0, tempErr).Once()
+	mockWriter.On("Write", payloads[0].proc).Return(0, nil).Once()
+
+	// The second payload fails with an error that is not temporary and is not retried
+	procErr := fmt.Errorf("proc error from test")
+	mockReader.On("Read").Return(payloads[1].raw, nil).Once()
+	t.mockProc.On("Process", derived, payloads[1].raw).Return(nil, procErr).Once()
+	taggedErr := stageError(StageProcess, mockReader, nil, procErr)
+	t.mockErrHandler.On("HandleError", derived, taggedErr).Return(taggedErr).Once()
+
+	t.setMockEOF()
+	t.pipeline.Run(ctx)
+}
+
+// This test checks that a fatal error stops the pipeline and is returned by Run along with any close errors
+func (t *PipelineSuite) TestFatalError() {
+	ctx, derived := newRunContext()
+	mockReader := t.mockReaders[0]
+	mockWriter := t.mockWriters[0]
+	payload := generatePayloads(1)[0]
+
+	fatalErr := NewFatalError(fmt.Errorf("fatal error from test"))
+	closeErr := fmt.Errorf("close error from test")
+	mockReader.On("Read").Return(payload.raw, nil).Once()
+	t.mockProc.On("Process", derived, payload.raw).Return(nil, fatalErr).Once()
+	taggedErr := stageError(StageProcess, mockReader, nil, fatalErr)
+	t.mockErrHandler.On("HandleError", derived, taggedErr).Return(taggedErr).Once()
+	mockWriter.On("Close").Return(closeErr).Once()
+
+	err := t.pipeline.Run(ctx)
+	t.Require().Error(err)
+	runErr, ok := err.(*RunError)
+	t.Require().True(ok)
+	t.Equal(taggedErr, runErr.Cause)
+	t.True(errors.Is(err, fatalErr))
+	t.Equal([]error{closeErr}, runErr.CloseErrors)
+}
+
+// This test checks that Run returns the context error when the pipeline is canceled
+func (t *PipelineSuite) TestCanceled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	t.mockReaders[0].On("Read").Return(nil, EOF).Maybe()
+	t.mockWriters[0].On("Close").Return(nil).Once()
+
+	err := t.pipeline.Run(ctx)
+	t.True(errors.Is(err, context.Canceled))
+}
+
+// This test checks that payloads which fail to process or write are sent to the dead-letter sink
+func (t *PipelineSuite) TestDeadLetter() {
+	ctx, derived := newRunContext()
+	mockReader := t.mockReaders[0]
+	mockWriter := t.mockWriters[0]
+	deadLetters := &mocks.PipeWriter{}
+	t.pipeline.SetDeadLetter(mockWriteCloser{deadLetters}, pencode.Printer{})
+	t.mockWriters = append(t.mockWriters, deadLetters)
+
+	payloads := generatePayloads(2)
+	procErr := fmt.Errorf("proc error from test")
+	writeErr := fmt.Errorf("write error from test")
+
+	mockReader.On("Read").Return(payloads[0].raw, nil).Once()
+	t.mockProc.On("Process", derived, payloads[0].raw).Return(nil, procErr).Once()
+	taggedErr := stageError(StageProcess, mockReader, nil, procErr)
+	t.mockErrHandler.On("HandleError", derived, taggedErr).Return(taggedErr).Once()
+
+	mockReader.On("Read").Return(payloads[1].raw, nil).Once()
+	t.mockProc.On("Process", derived, payloads[1].raw).Return(payloads[1].proc, nil).Once()
+	mockWriter.On("Write", payloads[1].proc).Return(0, writeErr).Once()
+	taggedWriteErr := stageError(StageWrite, mockReader, mockWriter, writeErr)
+	t.mockErrHandler.On("HandleError", derived, taggedWriteErr).Return(taggedWriteErr).Once()
+
+	var letters []string
+	deadLetters.On("Write", mock.Anything).Return(0, nil).Run(func(args mock.Arguments) {
+		letters = append(letters, string(args.Get(0).([]byte)))
+	}).Twice()
+	t.setMockEOF()
+	t.pipeline.Run(ctx)
+
+	t.Require().Len(letters, 2)
+	t.Contains(letters[0], "process")
+	t.Contains(letters[0], procErr.Error())
+	t.Contains(letters[1], "write")
+	t.Contains(letters[1], writeErr.Error())
+}
+
+// mockWriteCloser adapts a mock PipeWriter to an io.WriteCloser
+type mockWriteCloser struct {
+	m *mocks.PipeWriter
+}
+
+func (w mockWriteCloser) Write(b []byte) (int, error) {
+	return w.m.Write(b)
+}
+
+func (w mockWriteCloser) Close() error {
+	return w.m.Close()
+}
+
 // setMockEOF sets the readers to all return EOF on their next call and the writers to expect a call to Close()
 func (t *PipelineSuite) setMockEOF() {
 	for _, m := range t.mockReaders {
@@ -224,6 +397,191 @@ func (t *PipelineSuite) setMockEOF() {
 	}
 }
 
+// runKey marks the contexts derived from the context of a test run
+type runKey struct{}
+
+// newRunContext returns the context to run a pipeline with and a matcher for the contexts derived from it, which
+// the pipeline passes to processors and error handlers
+func newRunContext() (context.Context, interface{}) {
+	ctx := context.WithValue(context.Background(), runKey{}, true)
+	return ctx, mock.MatchedBy(func(ctx context.Context) bool {
+		return ctx.Value(runKey{}) == true
+	})
+}
+
+type errorHandlerFunc func(ctx context.Context, err error) error
+
+func (f errorHandlerFunc) HandleError(ctx context.Context, err error) error {
+	return f(ctx, err)
+}
+
+// blockingReader blocks on reads until it is closed
+type blockingReader chan struct{}
+
+func (r blockingReader) Read() (interface{}, error) {
+	<-r
+	return nil, EOF
+}
+
+func newQuietPipeline(opts ...Option) *Pipeline {
+	p := NewPipeline(opts...)
+	p.SetLogger(&defaultLogger{Logger: *log.New(ioutil.Discard, "", 0)})
+	p.SetErrorHandler(errorHandlerFunc(func(ctx context.Context, err error) error { return err }))
+	return p
+}
+
+// This test checks that Run cancels the remaining pipelines when one fails and only reports the failure
+func TestRunCancelsSiblings(t *testing.T) {
+	fatalErr := NewFatalError(fmt.Errorf("fatal error from test"))
+
+	failing := newQuietPipeline()
+	failing.readers = append(failing.readers, &mocks.PipeReader{})
+	failing.readers[0].(*mocks.PipeReader).On("Read").Return(1, nil)
+	failing.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		return nil, fatalErr
+	}))
+
+	blocked := make(blockingReader)
+	defer close(blocked)
+	sibling := newQuietPipeline()
+	sibling.readers = append(sibling.readers, blocked)
+
+	err := Run(context.Background(), failing, sibling)
+	require.IsType(t, RunErrors{}, err)
+	runErrs := err.(RunErrors)
+	require.Len(t, runErrs, 1)
+	cause := runErrs[0].(*RunError).Cause
+	assert.True(t, errors.Is(cause, fatalErr))
+	var stageErr *StageError
+	require.True(t, errors.As(cause, &stageErr))
+	assert.Equal(t, StageProcess, stageErr.Stage)
+}
+
+// counterReader returns an increasing count on every read
+type counterReader struct {
+	n int64
+}
+
+func (r *counterReader) Read() (interface{}, error) {
+	time.Sleep(time.Millisecond)
+	return atomic.AddInt64(&r.n, 1), nil
+}
+
+// collectWriter records everything written to it
+type collectWriter struct {
+	mu      sync.Mutex
+	results []interface{}
+	closed  bool
+	// afterClose counts the writes after the writer was closed
+	afterClose int
+}
+
+func (w *collectWriter) Write(result interface{}) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		w.afterClose++
+	}
+	w.results = append(w.results, result)
+	return 0, nil
+}
+
+func (w *collectWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+// This test checks that Shutdown drains in-flight payloads through joined pipelines before closing the writers
+func TestShutdown(t *testing.T) {
+	identity := ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return payload, nil
+	})
+	reader := &counterReader{}
+	p1 := newQuietPipeline(WithWorkers(4, Ordered))
+	p1.readers = append(p1.readers, reader)
+	p1.SetProcessor(identity)
+
+	p2 := newQuietPipeline(WithJoinBuffer(100, Block))
+	p2.SetProcessor(identity)
+	w := &collectWriter{}
+	p2.writers = append(p2.writers, w)
+	p1.Join(p2)
+
+	errs := make(chan error)
+	go func() {
+		errs <- Run(context.Background(), p1, p2)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, p1.Shutdown(context.Background()))
+	require.NoError(t, <-errs)
+
+	// Every payload that was read made it through both pipelines in order
+	read := atomic.LoadInt64(&reader.n)
+	require.Len(t, w.results, int(read))
+	for ii, v := range w.results {
+		assert.Equal(t, int64(ii+1), v)
+	}
+	assert.True(t, w.closed)
+}
+
+// This test checks that Shutdown stops the pipeline when it cannot drain before the deadline
+func TestShutdownDeadline(t *testing.T) {
+	blocked := make(blockingReader)
+	defer close(blocked)
+	p := newQuietPipeline()
+	p.readers = append(p.readers, blocked)
+
+	errs := make(chan error)
+	go func() {
+		errs <- p.Run(context.Background())
+	}()
+	// Give the reader time to block on a read
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, p.Shutdown(ctx))
+	assert.True(t, errors.Is(<-errs, ErrShutdownTimeout))
+}
+
+// This test checks that payloads still processing when the shutdown deadline passes are canceled and that nothing
+// is written once the writers are closed
+func TestShutdownDeadlineCancelsProcessors(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: numbers(1)})
+	p.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return payload, nil
+	}))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	errs := make(chan error)
+	go func() {
+		errs <- p.Run(context.Background())
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, p.Shutdown(ctx))
+	assert.True(t, errors.Is(<-errs, ErrShutdownTimeout))
+
+	select {
+	case <-canceled:
+	default:
+		t.Fatal("the processor context was not canceled")
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	assert.True(t, out.closed)
+	assert.Equal(t, 0, out.afterClose)
+}
+
 func TestPipeline(t *testing.T) {
 	s := PipelineSuite{}
 	suite.Run(t, &s)
@@ -0,0 +1,264 @@
+package pipeio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// ErrFrameTooLarge is returned when a frame exceeds the maximum length allowed by the Framer
+var ErrFrameTooLarge = errors.New("frame exceeds maximum length")
+
+// DefaultMaxLength is the largest frame read by the length prefixed Framers when their MaxLength is zero. It guards
+// against allocating huge buffers for a corrupt length prefix.
+const DefaultMaxLength = 64 << 20
+
+// NoMaxLength can be set as the MaxLength of a Framer to read frames of any length
+const NoMaxLength = math.MaxUint64
+
+// Framer splits a byte stream into whole messages (frames) and wraps messages into frames for writing
+type Framer interface {
+	// ReadFrame reads the next complete frame from the reader and returns its payload
+	ReadFrame(r *bufio.Reader) ([]byte, error)
+	// Frame wraps the payload into a frame
+	Frame(payload []byte) ([]byte, error)
+}
+
+// Delimited frames messages by terminating them with a delimiter, such as a newline
+type Delimited struct {
+	Delim []byte
+}
+
+// NewLineDelimited creates a Framer that frames messages by newlines
+func NewLineDelimited() Delimited {
+	return Delimited{Delim: []byte("\n")}
+}
+
+// ReadFrame reads up to and including the next delimiter and returns the frame without the delimiter.
+// A final frame which is not terminated by the delimiter is returned as is.
+func (d Delimited) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	if len(d.Delim) == 0 {
+		return nil, errors.New("delimiter must not be empty")
+	}
+	last := d.Delim[len(d.Delim)-1]
+	var frame []byte
+	for {
+		chunk, err := r.ReadBytes(last)
+		frame = append(frame, chunk...)
+		if err != nil {
+			if err == io.EOF && len(frame) > 0 {
+				return frame, nil
+			}
+			return nil, err
+		}
+		if bytes.HasSuffix(frame, d.Delim) {
+			return frame[:len(frame)-len(d.Delim)], nil
+		}
+	}
+}
+
+// Frame appends the delimiter to the payload. Payloads that are already terminated by the delimiter, such as the
+// output of pencode.JSONEncoder, are left as is.
+func (d Delimited) Frame(payload []byte) ([]byte, error) {
+	if bytes.HasSuffix(payload, d.Delim) {
+		payload = payload[:len(payload)-len(d.Delim)]
+	}
+	if bytes.Contains(payload, d.Delim) {
+		return nil, fmt.Errorf("payload contains the delimiter %q", d.Delim)
+	}
+	frame := make([]byte, 0, len(payload)+len(d.Delim))
+	return append(append(frame, payload...), d.Delim...), nil
+}
+
+// LengthPrefixed frames messages by prefixing them with their length as a 2, 4 or 8 byte unsigned integer
+type LengthPrefixed struct {
+	// Size is the number of bytes of the length prefix, either 2, 4 or 8
+	Size int
+	// Order is the byte order of the length prefix, binary.BigEndian if nil
+	Order binary.ByteOrder
+	// MaxLength is the largest frame that will be read, DefaultMaxLength if zero. Set it to NoMaxLength to read
+	// frames of any length.
+	MaxLength uint64
+}
+
+// ReadFrame reads the length prefix and then the payload
+func (l LengthPrefixed) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	order, err := l.check()
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, l.Size)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch l.Size {
+	case 2:
+		n = uint64(order.Uint16(prefix))
+	case 4:
+		n = uint64(order.Uint32(prefix))
+	case 8:
+		n = order.Uint64(prefix)
+	}
+	return readPayload(r, n, l.MaxLength)
+}
+
+// Frame prefixes the payload with its length
+func (l LengthPrefixed) Frame(payload []byte) ([]byte, error) {
+	order, err := l.check()
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, l.Size, l.Size+len(payload))
+	n := uint64(len(payload))
+	switch l.Size {
+	case 2:
+		if n > 1<<16-1 {
+			return nil, ErrFrameTooLarge
+		}
+		order.PutUint16(frame, uint16(n))
+	case 4:
+		if n > 1<<32-1 {
+			return nil, ErrFrameTooLarge
+		}
+		order.PutUint32(frame, uint32(n))
+	case 8:
+		order.PutUint64(frame, n)
+	}
+	return append(frame, payload...), nil
+}
+
+// check returns the byte order of the length prefix and an error if the size of the length prefix is unsupported
+func (l LengthPrefixed) check() (binary.ByteOrder, error) {
+	switch l.Size {
+	case 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("unsupported length prefix size %d", l.Size)
+	}
+	if l.Order == nil {
+		return binary.BigEndian, nil
+	}
+	return l.Order, nil
+}
+
+// Varint frames messages by prefixing them with their length as a protobuf-style unsigned varint
+type Varint struct {
+	// MaxLength is the largest frame that will be read, DefaultMaxLength if zero. Set it to NoMaxLength to read
+	// frames of any length.
+	MaxLength uint64
+}
+
+// ReadFrame reads the varint length prefix and then the payload
+func (v Varint) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	// Peek first so that a stream ending cleanly on a frame boundary returns io.EOF
+	if _, err := r.Peek(1); err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return readPayload(r, n, v.MaxLength)
+}
+
+// Frame prefixes the payload with its length
+func (v Varint) Frame(payload []byte) ([]byte, error) {
+	frame := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(payload))
+	n := binary.PutUvarint(frame, uint64(len(payload)))
+	return append(frame[:n], payload...), nil
+}
+
+// FixedSize frames messages as records of a fixed number of bytes
+type FixedSize struct {
+	// Size is the number of bytes of a record, which must be positive
+	Size int
+}
+
+// ReadFrame reads the next record
+func (f FixedSize) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	if f.Size < 1 {
+		return nil, fmt.Errorf("unsupported fixed frame size %d", f.Size)
+	}
+	frame := make([]byte, f.Size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Frame checks that the payload is exactly the size of a record
+func (f FixedSize) Frame(payload []byte) ([]byte, error) {
+	if f.Size < 1 {
+		return nil, fmt.Errorf("unsupported fixed frame size %d", f.Size)
+	}
+	if len(payload) != f.Size {
+		return nil, fmt.Errorf("fixed size frame expects %d bytes but got %d", f.Size, len(payload))
+	}
+	return payload, nil
+}
+
+// readPayload reads a payload of length n, failing if it is larger than max, or DefaultMaxLength if max is zero.
+// Payloads too large to allocate always fail.
+func readPayload(r io.Reader, n, max uint64) ([]byte, error) {
+	if max == 0 {
+		max = DefaultMaxLength
+	}
+	if n > max || n > math.MaxInt {
+		return nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// FrameReader reads whole frames from a byte stream. It satisfies the MessageReader interface and can be added to a
+// pipeline with AddMessageSource so that messages are not split or merged by the underlying reads.
+type FrameReader struct {
+	r      *bufio.Reader
+	framer Framer
+}
+
+// NewFrameReader creates a new FrameReader reading frames from the io.Reader
+func NewFrameReader(r io.Reader, f Framer) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r), framer: f}
+}
+
+// Read reads the next frame
+func (f *FrameReader) Read() ([]byte, error) {
+	return f.framer.ReadFrame(f.r)
+}
+
+// FrameEncoder is an encoder which frames the output of another encoder so that it can be read back by a FrameReader
+// using the same Framer
+type FrameEncoder struct {
+	enc    pencode.Encoder
+	framer Framer
+}
+
+// NewFrameEncoder creates a new FrameEncoder
+func NewFrameEncoder(enc pencode.Encoder, f Framer) *FrameEncoder {
+	return &FrameEncoder{enc: enc, framer: f}
+}
+
+// Encode encodes the payload and wraps it into a frame
+func (f *FrameEncoder) Encode(v interface{}) ([]byte, error) {
+	raw, err := f.enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return f.framer.Frame(raw)
+}
@@ -0,0 +1,119 @@
+package pipeio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// This test checks that frames written by each Framer are read back whole, regardless of how the stream is split
+func TestFramers(t *testing.T) {
+	messages := [][]byte{[]byte("hello"), []byte("a"), []byte("world!")}
+	testCases := []struct {
+		name   string
+		framer Framer
+	}{
+		{name: "newline", framer: NewLineDelimited()},
+		{name: "custom delimiter", framer: Delimited{Delim: []byte("\r\n")}},
+		{name: "2 byte big endian", framer: LengthPrefixed{Size: 2, Order: binary.BigEndian}},
+		{name: "4 byte little endian", framer: LengthPrefixed{Size: 4, Order: binary.LittleEndian}},
+		{name: "8 byte big endian", framer: LengthPrefixed{Size: 8, Order: binary.BigEndian}},
+		{name: "4 byte default order", framer: LengthPrefixed{Size: 4}},
+		{name: "varint", framer: Varint{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := bytes.Buffer{}
+			enc := NewFrameEncoder(pencode.PassThrough{}, tc.framer)
+			for _, msg := range messages {
+				frame, err := enc.Encode(msg)
+				require.NoError(t, err)
+				stream.Write(frame)
+			}
+
+			// Read one byte at a time to simulate a stream which splits messages arbitrarily
+			r := NewFrameReader(oneByteReader{&stream}, tc.framer)
+			for _, msg := range messages {
+				frame, err := r.Read()
+				require.NoError(t, err)
+				assert.Equal(t, msg, frame)
+			}
+			_, err := r.Read()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestFixedSize(t *testing.T) {
+	framer := FixedSize{Size: 3}
+	_, err := framer.Frame([]byte("toolong"))
+	assert.Error(t, err)
+
+	r := NewFrameReader(bytes.NewBufferString("abcdefgh"), framer)
+	for _, expected := range []string{"abc", "def"} {
+		frame, err := r.Read()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(frame))
+	}
+	_, err = r.Read()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Records must have at least one byte
+	_, err = NewFrameReader(bytes.NewBufferString("abc"), FixedSize{}).Read()
+	assert.Error(t, err)
+	_, err = FixedSize{}.Frame(nil)
+	assert.Error(t, err)
+}
+
+func TestFrameErrors(t *testing.T) {
+	_, err := NewFrameReader(bytes.NewReader([]byte{0, 10, 'a'}), LengthPrefixed{Size: 2, Order: binary.BigEndian}).Read()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = NewFrameReader(bytes.NewReader([]byte{0, 10, 'a'}), LengthPrefixed{Size: 2, Order: binary.BigEndian, MaxLength: 5}).Read()
+	assert.Equal(t, ErrFrameTooLarge, err)
+
+	// A corrupt length prefix is bounded by the default maximum length instead of allocating the frame
+	corrupt := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'}
+	_, err = NewFrameReader(bytes.NewReader(corrupt), LengthPrefixed{Size: 8, Order: binary.BigEndian}).Read()
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, err = NewFrameReader(bytes.NewReader(corrupt), LengthPrefixed{Size: 4, Order: binary.BigEndian}).Read()
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, err = NewFrameReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 'a'}), Varint{}).Read()
+	assert.Equal(t, ErrFrameTooLarge, err)
+	// Frames too large to allocate fail even without a maximum length
+	_, err = NewFrameReader(bytes.NewReader(corrupt), LengthPrefixed{Size: 8, Order: binary.BigEndian, MaxLength: NoMaxLength}).Read()
+	assert.Equal(t, ErrFrameTooLarge, err)
+
+	// Unsupported prefix sizes fail instead of panicking
+	for _, size := range []int{-1, 0, 3} {
+		_, err = NewFrameReader(bytes.NewReader(corrupt), LengthPrefixed{Size: size}).Read()
+		assert.Error(t, err)
+		_, err = LengthPrefixed{Size: size}.Frame([]byte("a"))
+		assert.Error(t, err)
+	}
+
+	_, err = NewLineDelimited().Frame([]byte("two\nlines"))
+	assert.Error(t, err)
+
+	frame, err := NewLineDelimited().Frame([]byte("{}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(frame))
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(b[:1])
+}
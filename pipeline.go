@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lobocv/pipeline/pencode"
 )
@@ -24,7 +26,9 @@ func (h *defaultErrorHandler) HandleError(ctx context.Context, err error) error
 	return err
 }
 
-type errorHandler interface {
+// ErrorHandler handles the errors of the pipeline. It is called with every error the pipeline encounters, after the
+// error policy has decided how to react to it. The pipeline stops if the returned error is Fatal.
+type ErrorHandler interface {
 	HandleError(context.Context, error) error
 }
 
@@ -59,34 +63,129 @@ type Pipeline struct {
 	writers []pipeWriter
 
 	// error handling function for pipeline errors
-	errHandler errorHandler
-
-	// Done channel used to stop the pipeline if a fatal error occurs
-	done chan struct{}
+	errHandler ErrorHandler
+
+	// errPolicy decides how the pipeline reacts to failed payloads and stageErrPolicy overrides it for specific
+	// stages. readerControls holds the readers paused or stopped by the error policy.
+	errPolicy      ErrorPolicy
+	stageErrPolicy map[Stage]ErrorPolicy
+	readerControls map[pipeReader]readerControl
+
+	// stopped is closed to stop the pipeline when a fatal error occurs, fatal holds the error
+	stopped  chan struct{}
+	stopOnce sync.Once
+	fatal    error
+
+	// draining is closed by Shutdown to stop reading new input, aborted is closed when the shutdown deadline passes,
+	// halted is closed once Run stops reading and finished is closed once Run has returned
+	draining  chan struct{}
+	drainOnce sync.Once
+	aborted   chan struct{}
+	abortOnce sync.Once
+	halted    chan struct{}
+	finished  chan struct{}
+
+	// downstream are the pipelines joined to the output of this pipeline
+	downstream []*Pipeline
+
+	// workers is the number of goroutines processing payloads for each reader
+	workers int
+	// ordering determines if concurrently processed results are written in input order
+	ordering Ordering
+
+	// stageBuffer configures the buffers between the read, process and write stages
+	stageBuffer bufferConfig
+	// joinBuffer configures the buffer of couplers feeding this pipeline
+	joinBuffer bufferConfig
+	// dropped counts the items dropped by full buffers
+	dropped uint64
+
+	// deadLetters receives the payloads that fail in the pipeline
+	deadLetters *sink
+
+	// window aggregates the results into windows of time
+	window *Window
+
+	// router selects the writers for each result and unrouted counts the results without any
+	router   Router
+	unrouted uint64
+
+	// batcher groups the results into batches before they are written
+	batcher *batcher
+
+	// watermarks tracks the progress in event time of each reader
+	watermarks *watermarks
+	// lateOutput receives the results which arrive after their windows closed and late counts them
+	lateOutput *sink
+	late       uint64
+
+	// dedup drops duplicate payloads and duplicates counts them
+	dedup      *dedup
+	duplicates uint64
+
+	// limit is the rate limit of the pipeline and readerLimits are the rate limits of individual readers
+	limit        *tokenBucket
+	readerLimits map[pipeReader]*tokenBucket
+
+	// timeouts configures the processing deadline of each payload
+	timeouts *TimeoutConfig
+	// panicPolicy determines whether recovered panics stop the pipeline
+	panicPolicy PanicPolicy
+
+	// state is the keyed state of the processor, saved every snapshotInterval if it is persistent
+	state            State
+	snapshotInterval time.Duration
+
+	// retry is the retry policy for Temporary errors and stageRetry overrides it for specific stages
+	retry      RetryPolicy
+	stageRetry map[Stage]RetryPolicy
 }
 
-// NewPipeline creates a new pipeline
-func NewPipeline() *Pipeline {
-	return &Pipeline{errHandler: &defaultErrorHandler{}, log: &defaultLogger{Logger: *log.New(os.Stderr, "", log.LstdFlags)}, done: make(chan struct{})}
+// NewPipeline creates a new pipeline configured with the given options
+func NewPipeline(opts ...Option) *Pipeline {
+	p := &Pipeline{errHandler: &defaultErrorHandler{}, log: &defaultLogger{Logger: *log.New(os.Stderr, "", log.LstdFlags)}, stopped: make(chan struct{}),
+		draining: make(chan struct{}), aborted: make(chan struct{}), halted: make(chan struct{}),
+		finished: make(chan struct{})}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // AddMessageSource appends a MessageReader to the input of this pipeline
-func (p *Pipeline) AddMessageSource(r MessageReader, dec pencode.Decoder) {
+func (p *Pipeline) AddMessageSource(r MessageReader, dec pencode.Decoder, opts ...ReaderOption) {
+	p.readerLock.Lock()
+	defer p.readerLock.Unlock()
+	p.addReader(newMessageInput(r, dec), opts)
+}
+
+// AddEnvelopeSource appends an EnvelopeReader to the input of this pipeline. The metadata of each message is
+// available to the processor through MessageFromContext and is carried through to writers and joined pipelines.
+func (p *Pipeline) AddEnvelopeSource(r EnvelopeReader, dec pencode.Decoder, opts ...ReaderOption) {
+	p.readerLock.Lock()
+	defer p.readerLock.Unlock()
+	p.addReader(newEnvelopeInput(r, dec), opts)
+}
+
+// AddSource appends a Source of already decoded payloads to the input of this pipeline
+func (p *Pipeline) AddSource(s Source, opts ...ReaderOption) {
 	p.readerLock.Lock()
 	defer p.readerLock.Unlock()
-	p.readers = append(p.readers, newMessageInput(r, dec))
+	p.addReader(s, opts)
 }
 
-// AddReader appends an io.Reader to the input of this pipeline
-func (p *Pipeline) AddReader(r io.Reader, dec pencode.Decoder, buf []byte) {
+// AddReader appends an io.Reader to the input of this pipeline. The options can limit the rate at which it is read.
+func (p *Pipeline) AddReader(r io.Reader, dec pencode.Decoder, buf []byte, opts ...ReaderOption) {
 	p.readerLock.Lock()
 	defer p.readerLock.Unlock()
-	p.readers = append(p.readers, newBufferReader(r, buf, dec))
+	p.addReader(newBufferReader(r, buf, dec), opts)
 }
 
 // RemoveReader removes the reader from the pipeline
 func (p *Pipeline) RemoveReader(r pipeReader) {
 	p.log.Println("Removing reader")
+	p.readerLock.Lock()
+	defer p.readerLock.Unlock()
 	n := 0
 	for _, otherReader := range p.readers {
 		if r != otherReader {
@@ -94,24 +193,57 @@ func (p *Pipeline) RemoveReader(r pipeReader) {
 			n++
 		}
 	}
-	p.readerLock.Lock()
 	p.readers = p.readers[:n]
-	p.readerLock.Unlock()
+	delete(p.readerLimits, r)
+	delete(p.readerControls, r)
+	if p.watermarks != nil {
+		p.watermarks.finish(r)
+	}
 	p.log.Println("Readers remaining", p.readers)
 }
 
-// AddWriter appends a io.Writer to the output of this pipeline
-func (p *Pipeline) AddWriter(w io.WriteCloser, enc pencode.Encoder) {
-	p.writers = append(p.writers, &pipeOutput{w: w, enc: enc})
+// AddWriter appends a io.Writer to the output of this pipeline.
+// By default every result is written to the writer, the options restrict it to the results selected by the Router
+// and can limit the rate at which it is written to.
+func (p *Pipeline) AddWriter(w io.WriteCloser, enc pencode.Encoder, opts ...WriterOption) {
+	var out pipeWriter = &pipeOutput{w: w, enc: enc}
+	if len(opts) > 0 {
+		rw := &routedWriter{pipeWriter: out}
+		for _, opt := range opts {
+			opt(rw)
+		}
+		out = rw
+	}
+	p.writers = append(p.writers, out)
 }
 
 // Join joins the output of this pipeline to the input of the provided pipeline
 func (p *Pipeline) Join(out *Pipeline) {
-	p.readerLock.Lock()
-	defer p.readerLock.Unlock()
-	c := newCoupler()
+	p.writers = append(p.writers, p.couple(out))
+}
+
+// couple creates a coupler feeding the output of this pipeline to the input of the provided pipeline
+func (p *Pipeline) couple(out *Pipeline) *coupler {
+	c := p.coupler(out)
+	out.readerLock.Lock()
 	out.readers = append(out.readers, c)
-	p.writers = append(p.writers, c)
+	out.readerLock.Unlock()
+	return c
+}
+
+// coupler creates a coupler for writing the output of this pipeline to the provided pipeline without adding it
+// to the readers of the provided pipeline
+func (p *Pipeline) coupler(out *Pipeline) *coupler {
+	p.downstream = append(p.downstream, out)
+	c := newCoupler(out.joinBuffer, &out.dropped)
+	c.upstream, c.halted = p, out.halted
+	return c
+}
+
+// Dropped returns the number of items that have been dropped by full buffers or rate limits in this pipeline,
+// including the buffers of couplers feeding this pipeline
+func (p *Pipeline) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
 }
 
 // SetProcessor sets the processor on the pipeline
@@ -119,128 +251,415 @@ func (p *Pipeline) SetProcessor(proc Processor) {
 	p.proc = proc
 }
 
-// SetErrorHandler sets the processor on the pipeline
-func (p *Pipeline) SetErrorHandler(h errorHandler) {
+// SetErrorHandler sets the error handler of the pipeline
+func (p *Pipeline) SetErrorHandler(h ErrorHandler) {
 	p.errHandler = h
 }
 
+// SetDeadLetter sets the dead-letter sink of the pipeline. Payloads that fail to decode, process or write are
+// wrapped in a DeadLetter envelope, encoded and written to the sink so that they can be inspected or replayed later.
+func (p *Pipeline) SetDeadLetter(w io.WriteCloser, enc pencode.Encoder) {
+	p.deadLetters = &sink{out: pipeOutput{w: w, enc: enc}}
+}
+
 // SetLogger sets the logger on the pipeline
 func (p *Pipeline) SetLogger(l Logger) {
 	p.log = l
 }
 
-// Run is a blocking call that engages the pipeline
-func (p *Pipeline) Run(ctx context.Context) {
+// listenerStopTimeout is how long Run waits for the listeners to stop before closing the writers
+const listenerStopTimeout = time.Second
+
+// Run is a blocking call that engages the pipeline. It returns nil if the pipeline stopped because all of its readers
+// reached EOF. Otherwise a *RunError is returned containing the fatal error or context error that stopped the
+// pipeline and any errors encountered while closing the writers.
+func (p *Pipeline) Run(ctx context.Context) error {
 	p.log.Println("Starting pipeline")
-	for _, r := range p.readers {
-		go p.listen(ctx, r)
+	defer close(p.finished)
+	stopState := p.startState()
+	p.readerLock.Lock()
+	readers := append([]pipeReader(nil), p.readers...)
+	p.readerLock.Unlock()
+
+	// The listeners are canceled when the pipeline stops so that in-flight payloads are abandoned
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listeners := sync.WaitGroup{}
+	for _, r := range readers {
+		listeners.Add(1)
+		go func(r pipeReader) {
+			defer listeners.Done()
+			p.listen(listenCtx, r)
+		}(r)
 	}
-loop:
-	for {
-		select {
-		case <-ctx.Done():
+	finished := make(chan struct{})
+	go func() {
+		listeners.Wait()
+		close(finished)
+	}()
+
+	stopWindow := p.startWindow(ctx)
+	stopBatcher := p.startBatcher(ctx)
+
+	var runErr RunError
+	select {
+	case <-ctx.Done():
+		p.log.Println("Pipeline canceled")
+		runErr.Cause = ctx.Err()
+	case <-p.stopped:
+	case <-p.aborted:
+		p.log.Println("Pipeline shutdown deadline exceeded")
+		runErr.Cause = ErrShutdownTimeout
+	case <-finished:
+		p.readerLock.Lock()
+		remaining := len(p.readers)
+		p.readerLock.Unlock()
+		// Readers that did not reach EOF were stopped by the context or a shutdown
+		if p.isDraining() {
+			p.log.Println("Pipeline drained")
+		} else if remaining > 0 {
 			p.log.Println("Pipeline canceled")
-			break loop
-		case <-p.done:
-			if len(p.readers) == 0 {
-				p.log.Println("No more readers. Stopping pipeline")
-				break loop
-			}
+			runErr.Cause = ctx.Err()
+		} else {
+			p.log.Println("No more readers. Stopping pipeline")
 		}
 	}
+	if fatal := p.fatalError(); fatal != nil {
+		p.log.Println("Pipeline stopped by fatal error: ", fatal)
+		runErr.Cause = fatal
+	}
+
+	// Wait for the listeners to stop so that nothing is written to the writers once they are closed. Listeners blocked
+	// in a reader or processor which ignores its context are abandoned after a while. Upstream pipelines blocked on
+	// writing to this pipeline are released.
+	close(p.halted)
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(listenerStopTimeout):
+		p.log.Println("Abandoning listeners which did not stop in time")
+	}
+	stopWindow()
+	stopBatcher()
+	stopState()
+
 	for _, w := range p.writers {
+		p.dropSpill(w)
 		p.log.Println("Closing writer")
 		err := w.Close()
 		if err != nil {
 			p.log.Println("Error closing writer: ", err)
+			runErr.CloseErrors = append(runErr.CloseErrors, err)
 		}
 		p.log.Println("Finished closing writer")
 
 	}
+	if p.deadLetters != nil {
+		if err := p.deadLetters.out.Close(); err != nil {
+			p.log.Println("Error closing dead-letter writer: ", err)
+			runErr.CloseErrors = append(runErr.CloseErrors, err)
+		}
+	}
+	if p.lateOutput != nil {
+		if err := p.lateOutput.out.Close(); err != nil {
+			p.log.Println("Error closing late output writer: ", err)
+			runErr.CloseErrors = append(runErr.CloseErrors, err)
+		}
+	}
+	if runErr.Cause != nil || len(runErr.CloseErrors) > 0 {
+		return &runErr
+	}
 	p.log.Println("Exiting pipeline gracefully")
+	return nil
+}
+
+// stop stops the pipeline due to a fatal error. Only the first fatal error is kept.
+func (p *Pipeline) stop(err error) {
+	p.stopOnce.Do(func() {
+		p.readerLock.Lock()
+		p.fatal = err
+		p.readerLock.Unlock()
+		close(p.stopped)
+	})
+}
+
+// fatalError returns the fatal error that stopped the pipeline, if any
+func (p *Pipeline) fatalError() error {
+	p.readerLock.Lock()
+	defer p.readerLock.Unlock()
+	return p.fatal
 }
 
 // listen starts processing data for a given pipeReader
 func (p *Pipeline) listen(ctx context.Context, r pipeReader) {
-	var (
-		errChan = make(chan error, len(p.readers))
-	)
+	if p.workers > 1 || p.stageBuffer.size > 0 {
+		p.listenConcurrent(ctx, r)
+		return
+	}
+	p.readerLock.Lock()
+	errChan := make(chan error, len(p.readers))
+	p.readerLock.Unlock()
 	p.log.Println("Starting reader")
 loop:
 	for {
 		select {
 		default:
+			if !p.awaitReader(ctx, r) {
+				p.RemoveReader(r)
+				break loop
+			}
 			// Perform a blocking read on the pipeReader
-			dataPayload, err := r.Read()
+			it, err := p.read(ctx, r)
 			if err != nil {
 				if err == EOF {
 					p.log.Println("Reader reached EOF")
 					p.RemoveReader(r)
-					p.done <- struct{}{}
 					break loop
 				}
 				p.log.Error("Error during read: %s", err)
 				errChan <- err
 				continue
 			}
+			p.advanceWatermark(r, it)
+			// Payloads dropped by a rate limit are not remembered by dedup, so that their redelivery is processed
+			if !p.admit(ctx, r) || p.duplicate(it) {
+				continue
+			}
 
 			// Pass the payload to be processed
-			result, err := p.proc.Process(ctx, dataPayload)
+			result, err := p.process(ctx, r, it)
 			if err != nil {
 				p.log.Error("Error during processing: %s", err)
-				errChan <- err
+				p.forget(it)
+				errChan <- stageError(StageProcess, r, nil, err)
 				continue
 			}
 
 			// write the results of the payload
-			if err = p.write(result); err != nil {
+			if err = p.emit(ctx, r, it, result); err != nil {
+				p.forget(it)
 				errChan <- err
 				continue
 			}
 
 		case err := <-errChan:
-			err = p.errHandler.HandleError(ctx, err)
-			if _, ok := err.(FatalError); ok {
-				p.done <- struct{}{}
-			}
+			p.handleError(ctx, err)
 		case <-ctx.Done():
 			p.log.Println("Stopping reading from reader")
 			break loop
+		case <-p.stopped:
+			p.log.Println("Stopping reading from reader")
+			break loop
+		case <-p.drainSignal(r):
+			p.log.Println("Stopping reading from reader")
+			break loop
 		}
 	}
 
 	p.log.Println("Stopping reader")
 }
 
-// write implements pipeWriter as a multi-writer. It encodes and then writes the payload to all registered PipeWriters
-// This differs from io.MultiWriter because it does not stop writing on errors and instead returns a combined error
-// for any failing writes.
-func (p *Pipeline) write(results interface{}) error {
+// handleError passes the error to the error handler and stops the pipeline if the handler deems it fatal
+func (p *Pipeline) handleError(ctx context.Context, err error) {
+	err = p.errHandler.HandleError(ctx, err)
+	if isFatal(err) {
+		p.stop(err)
+	}
+}
+
+// retryPolicy returns the retry policy for the given stage
+func (p *Pipeline) retryPolicy(stage Stage) RetryPolicy {
+	if policy, ok := p.stageRetry[stage]; ok {
+		return policy
+	}
+	return p.retry
+}
+
+// read reads and decodes the next item from the pipeReader, retrying each on Temporary errors and as decided by the
+// error policy. Items that fail to decode are sent to the dead-letter sink unless the error policy skips them.
+// Errors other than EOF are tagged with the reader.
+func (p *Pipeline) read(ctx context.Context, r pipeReader) (it item, err error) {
+	var decoder interface {
+		Decode(raw []byte) (interface{}, error)
+	}
+
+	switch rr := r.(type) {
+	case rawPipeReader:
+		decoder = rr
+		_, err = p.attempt(ctx, r, Failure{Stage: StageRead}, func() (err error) {
+			defer recoverPanic(StageRead, nil, &err)
+			it.raw, err = rr.ReadRaw()
+			return err
+		})
+	case envelopePipeReader:
+		decoder = rr
+		_, err = p.attempt(ctx, r, Failure{Stage: StageRead}, func() (err error) {
+			defer recoverPanic(StageRead, nil, &err)
+			it.msg, err = rr.ReadMessage()
+			return err
+		})
+		if err == nil {
+			it.raw, _ = it.msg.Payload.([]byte)
+		}
+	default:
+		_, err = p.attempt(ctx, r, Failure{Stage: StageRead}, func() (err error) {
+			defer recoverPanic(StageRead, nil, &err)
+			it.payload, err = r.Read()
+			return err
+		})
+		// Messages are passed along by joined pipelines. The same message can be written to several downstream
+		// pipelines, so each of them gets its own copy.
+		if msg, ok := it.payload.(*Message); ok && err == nil {
+			it.msg, it.payload = msg.clone(), msg.Payload
+		}
+		return it, p.readError(r, err)
+	}
+	if err != nil {
+		return it, p.readError(r, err)
+	}
+	action, err := p.attempt(ctx, r, Failure{Stage: StageDecode, Raw: it.raw}, func() (err error) {
+		defer recoverPanic(StageDecode, it.raw, &err)
+		it.payload, err = decoder.Decode(it.raw)
+		return err
+	})
+	if err != nil {
+		if action != SkipPayload {
+			p.deadLetter(StageDecode, r, it.raw, nil, err)
+		}
+		err = stageError(StageDecode, r, nil, err)
+	}
+	if it.msg != nil {
+		it.msg.Payload = it.payload
+	}
+	return it, err
+}
+
+// readError tags an error from reading the pipeReader, leaving EOF as is
+func (p *Pipeline) readError(r pipeReader, err error) error {
+	if err == nil || err == EOF {
+		return err
+	}
+	return stageError(StageRead, r, nil, err)
+}
+
+// process passes the item's payload to the processor, retrying on Temporary errors and as decided by the error policy.
+// If the item was read with its metadata, the message is made available to the processor through the context.
+// Payloads that fail to process are sent to the dead-letter sink unless the error policy skips them.
+func (p *Pipeline) process(ctx context.Context, r pipeReader, it item) (result interface{}, err error) {
+	if it.msg != nil {
+		ctx = withMessage(ctx, it.msg)
+	}
+	if p.state != nil {
+		ctx = withState(ctx, p.state)
+	}
+	action, err := p.attempt(ctx, r, Failure{Stage: StageProcess, Raw: it.raw, Payload: it.payload}, func() error {
+		result, err = p.processOnce(ctx, it.payload)
+		return err
+	})
+	if err != nil && action != SkipPayload {
+		p.deadLetter(StageProcess, r, it.raw, it.payload, err)
+	}
+	return result, err
+}
+
+// emit writes the result of processing an item. Results are expanded so that each of them is written separately
+// and an empty Results writes nothing. Results that fail to write are sent to the dead-letter sink.
+// If the pipeline has a window, the results are added to it and the windows that closed are written instead.
+func (p *Pipeline) emit(ctx context.Context, r pipeReader, it item, result interface{}) error {
+	results, ok := result.(Results)
+	if !ok {
+		results = Results{result}
+	}
+	if p.window != nil {
+		watermark, tracked := p.watermark()
+		closed, late := p.window.add(results, time.Now(), watermark, tracked)
+		p.writeLate(r, it, late)
+		return p.writeAll(ctx, r, item{}, closed)
+	}
+	return p.writeAll(ctx, r, it, results)
+}
+
+// writeAll writes each of the results of an item
+// If the pipeline batches its results, they are added to the current batch and the batches that are full are written
+// instead.
+func (p *Pipeline) writeAll(ctx context.Context, r pipeReader, it item, results Results) error {
 	var errors []error
-	for _, w := range p.writers {
-		if _, err := w.Write(results); err != nil {
-			p.log.Error("Error during write: %s", err)
+	if p.batcher != nil {
+		outputs := make(Results, 0, len(results))
+		for _, res := range results {
+			outputs = append(outputs, it.output(res))
+		}
+		for _, batch := range p.batcher.add(outputs, time.Now()) {
+			if err := p.writeBatch(ctx, batch); err != nil {
+				errors = append(errors, err)
+			}
+		}
+		results = nil
+	}
+	for _, res := range results {
+		if err := p.write(ctx, r, it.output(res)); err != nil {
+			if !skipped(err) {
+				p.deadLetter(StageWrite, r, it.raw, res, err)
+			}
 			errors = append(errors, err)
 		}
 	}
+	return combineErrors(errors...)
+}
 
-	if len(errors) > 0 {
-		return overallError(errors...)
+// write implements pipeWriter as a multi-writer. It encodes and then writes the payload to the PipeWriters selected
+// by the router
+// This differs from io.MultiWriter because it does not stop writing on errors and instead returns a MultiError
+// of the failing writes, each tagged with its writer. Each write is retried on Temporary errors and as decided by the
+// error policy, and skipped while the writer's circuit breaker is open.
+func (p *Pipeline) write(ctx context.Context, r pipeReader, results interface{}) error {
+	var errors []error
+	for _, w := range p.route(results) {
+		err := p.writeTo(ctx, r, w, []interface{}{results}, func() (int, error) {
+			return w.Write(results)
+		})
+		if err != nil {
+			p.log.Error("Error during write: %s", err)
+			errors = append(errors, err)
+		}
 	}
-	return nil
+	return combineErrors(errors...)
 }
 
 // Run engages all the provided pipelines. This is useful for when multiple pipelines are coupled together
-// This function blocks until all pipelines have finished running
-func Run(ctx context.Context, pipelines ...*Pipeline) {
-	wg := sync.WaitGroup{}
+// This function blocks until all pipelines have finished running. When a pipeline fails, the remaining pipelines
+// are canceled and the errors of the pipelines that failed are returned as RunErrors.
+func Run(ctx context.Context, pipelines ...*Pipeline) error {
+	groupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	var (
+		wg     = sync.WaitGroup{}
+		mu     sync.Mutex
+		errors RunErrors
+	)
 	for _, p := range pipelines {
 		wg.Add(1)
 		go func(p *Pipeline) {
 			defer wg.Done()
-			p.Run(ctx)
+			err := p.Run(groupCtx)
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// Pipelines canceled because a sibling failed are not failures themselves
+			if runErr, ok := err.(*RunError); ok && runErr.canceledBy(groupCtx, ctx) {
+				return
+			}
+			errors = append(errors, err)
+			cancel()
 		}(p)
 	}
 	wg.Wait()
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
 }
@@ -0,0 +1,286 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorAction is the reaction of the pipeline to a payload that failed in one of its stages. The zero value is
+// DeadLetterPayload.
+type ErrorAction struct {
+	kind  actionKind
+	pause time.Duration
+}
+
+type actionKind int
+
+const (
+	actionDeadLetter actionKind = iota
+	actionSkip
+	actionRetry
+	actionPause
+	actionStopReader
+	actionStopPipeline
+)
+
+var (
+	// DeadLetterPayload sends the payload to the dead-letter sink and continues with the next payload. It is the
+	// default reaction to errors.
+	DeadLetterPayload = ErrorAction{kind: actionDeadLetter}
+	// SkipPayload drops the payload without sending it to the dead-letter sink
+	SkipPayload = ErrorAction{kind: actionSkip}
+	// RetryPayload executes the stage again for the payload after the backoff of the stage's retry policy
+	RetryPayload = ErrorAction{kind: actionRetry}
+	// StopReader sends the payload to the dead-letter sink and stops reading from the reader it came from
+	StopReader = ErrorAction{kind: actionStopReader}
+	// StopPipeline sends the payload to the dead-letter sink and stops the pipeline with the error
+	StopPipeline = ErrorAction{kind: actionStopPipeline}
+)
+
+// PauseReader sends the payload to the dead-letter sink and pauses the reader it came from for the given duration
+func PauseReader(d time.Duration) ErrorAction {
+	return ErrorAction{kind: actionPause, pause: d}
+}
+
+func (a ErrorAction) String() string {
+	switch a.kind {
+	case actionDeadLetter:
+		return "dead-letter"
+	case actionSkip:
+		return "skip"
+	case actionRetry:
+		return "retry"
+	case actionPause:
+		return fmt.Sprintf("pause reader for %s", a.pause)
+	case actionStopReader:
+		return "stop reader"
+	case actionStopPipeline:
+		return "stop pipeline"
+	}
+	return "unknown"
+}
+
+// Decide always reacts with the action, so that actions can be used as an ErrorPolicy
+func (a ErrorAction) Decide(ctx context.Context, f Failure) ErrorAction {
+	return a
+}
+
+// Failure describes a payload that failed in a stage of the pipeline
+type Failure struct {
+	// Stage is the stage of the pipeline in which the payload failed
+	Stage Stage
+	// Reader identifies the reader of the payload, if known
+	Reader string
+	// Writer identifies the writer for write failures
+	Writer string
+	// Raw is the original input the payload was decoded from, if available
+	Raw []byte
+	// Payload is the decoded payload for processing failures or the result for write failures. Batches that fail to
+	// write are given as Results.
+	Payload interface{}
+	// Err is the error of the stage, after it was retried by the retry policy of the stage
+	Err error
+	// Attempt is the number of times the payload has failed in the stage, starting at 1
+	Attempt int
+}
+
+// ErrorPolicy decides how the pipeline reacts to a payload that failed in one of its stages. The error is passed to
+// the error handler regardless of the decision. Policies are called concurrently by pipelines with several readers
+// or workers.
+type ErrorPolicy interface {
+	Decide(ctx context.Context, f Failure) ErrorAction
+}
+
+// ErrorPolicyFunc is a function that implements the ErrorPolicy interface
+type ErrorPolicyFunc func(ctx context.Context, f Failure) ErrorAction
+
+// Decide calls the function
+func (fn ErrorPolicyFunc) Decide(ctx context.Context, f Failure) ErrorAction {
+	return fn(ctx, f)
+}
+
+// WithErrorPolicy sets the error policy that decides how the pipeline reacts to payloads which fail in any stage
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(p *Pipeline) {
+		p.errPolicy = policy
+	}
+}
+
+// WithStageErrorPolicy overrides the error policy for a specific stage of the pipeline
+func WithStageErrorPolicy(stage Stage, policy ErrorPolicy) Option {
+	return func(p *Pipeline) {
+		if p.stageErrPolicy == nil {
+			p.stageErrPolicy = make(map[Stage]ErrorPolicy)
+		}
+		p.stageErrPolicy[stage] = policy
+	}
+}
+
+// Threshold returns an ErrorPolicy which reacts to errors with the below policy until more than max errors occur
+// within the window, and then with the exceeded action. For example, Threshold(10, time.Minute, StopPipeline,
+// DeadLetterPayload) stops the pipeline once more than 10 payloads failed within a minute.
+func Threshold(max int, window time.Duration, exceeded ErrorAction, below ErrorPolicy) ErrorPolicy {
+	if below == nil {
+		below = DeadLetterPayload
+	}
+	return &threshold{max: max, window: window, exceeded: exceeded, below: below}
+}
+
+// threshold counts the errors within a sliding window
+type threshold struct {
+	max      int
+	window   time.Duration
+	exceeded ErrorAction
+	below    ErrorPolicy
+
+	mu     sync.Mutex
+	errors []time.Time
+}
+
+func (t *threshold) Decide(ctx context.Context, f Failure) ErrorAction {
+	if t.count(time.Now()) > t.max {
+		return t.exceeded
+	}
+	return t.below.Decide(ctx, f)
+}
+
+// count records an error at the given time and returns the number of errors within the window
+func (t *threshold) count(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, at := range t.errors {
+		if now.Sub(at) < t.window {
+			t.errors[n] = at
+			n++
+		}
+	}
+	t.errors = append(t.errors[:n], now)
+	// Only the most recent errors are needed to know if the threshold is exceeded
+	if len(t.errors) > t.max+1 {
+		t.errors = t.errors[len(t.errors)-t.max-1:]
+	}
+	return len(t.errors)
+}
+
+// errorPolicy returns the error policy for the given stage
+func (p *Pipeline) errorPolicy(stage Stage) ErrorPolicy {
+	if policy, ok := p.stageErrPolicy[stage]; ok {
+		return policy
+	}
+	if p.errPolicy != nil {
+		return p.errPolicy
+	}
+	return DeadLetterPayload
+}
+
+// attempt executes fn for the payload of a stage until it succeeds, retrying Temporary errors by the retry policy of
+// the stage and then for as long as the error policy decides to retry. The action decided for the last error is
+// returned with it. EOF from the read stage is returned as is.
+func (p *Pipeline) attempt(ctx context.Context, r pipeReader, f Failure, fn func() error) (ErrorAction, error) {
+	retry := p.retryPolicy(f.Stage)
+	for f.Attempt = 1; ; f.Attempt++ {
+		f.Err = p.classifyPanic(retry.do(ctx, fn))
+		if f.Err == nil || (f.Stage == StageRead && f.Err == EOF) {
+			return DeadLetterPayload, f.Err
+		}
+		action := p.decide(ctx, r, f)
+		if action != RetryPayload {
+			return action, f.Err
+		}
+		select {
+		case <-time.After(retry.backoff(f.Attempt)):
+		case <-ctx.Done():
+			return DeadLetterPayload, f.Err
+		case <-p.stopped:
+			return DeadLetterPayload, f.Err
+		}
+	}
+}
+
+// decide applies the error policy of the stage to the failure, pausing or stopping the reader or stopping the
+// pipeline as decided. Failures without a reader, such as those of windows and batches, pause or stop all readers.
+func (p *Pipeline) decide(ctx context.Context, r pipeReader, f Failure) ErrorAction {
+	if r != nil {
+		f.Reader = readerName(r)
+	}
+	action := p.errorPolicy(f.Stage).Decide(ctx, f)
+	switch action.kind {
+	case actionPause, actionStopReader:
+		p.log.Printf("Error policy decided to %s after %s error: %s", action, f.Stage, f.Err)
+		p.controlReaders(r, action)
+	case actionStopPipeline:
+		p.log.Printf("Error policy decided to %s after %s error: %s", action, f.Stage, f.Err)
+		p.stop(&StageError{Stage: f.Stage, Reader: f.Reader, Writer: f.Writer, Err: f.Err})
+	}
+	return action
+}
+
+// readerControl is the pause or stop of a reader decided by the error policy
+type readerControl struct {
+	stopped  bool
+	resumeAt time.Time
+}
+
+// controlReaders pauses or stops the reader, or all readers if it is nil
+func (p *Pipeline) controlReaders(r pipeReader, action ErrorAction) {
+	p.readerLock.Lock()
+	defer p.readerLock.Unlock()
+	readers := []pipeReader{r}
+	if r == nil {
+		readers = p.readers
+	}
+	if p.readerControls == nil {
+		p.readerControls = make(map[pipeReader]readerControl)
+	}
+	for _, r := range readers {
+		control := p.readerControls[r]
+		if action.kind == actionStopReader {
+			control.stopped = true
+		} else {
+			control.resumeAt = time.Now().Add(action.pause)
+		}
+		p.readerControls[r] = control
+	}
+}
+
+// awaitReader waits while the reader is paused by the error policy and returns false once the reader is stopped by
+// it
+func (p *Pipeline) awaitReader(ctx context.Context, r pipeReader) bool {
+	p.readerLock.Lock()
+	control := p.readerControls[r]
+	p.readerLock.Unlock()
+	if control.stopped {
+		p.log.Println("Reader stopped by error policy")
+		return false
+	}
+
+	d := time.Until(control.resumeAt)
+	if d <= 0 {
+		return true
+	}
+	p.log.Println("Reader paused by error policy for", d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-p.stopped:
+	case <-p.drainSignal(r):
+	}
+	return true
+}
+
+// failedPayload returns the payload of the results that failed to write, or the results of a batch
+func failedPayload(results []interface{}) interface{} {
+	if len(results) == 1 {
+		return payloadOf(results[0])
+	}
+	payloads := make(Results, 0, len(results))
+	for _, result := range results {
+		payloads = append(payloads, payloadOf(result))
+	}
+	return payloads
+}
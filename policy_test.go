@@ -0,0 +1,163 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// failOn returns a processor which fails on the given payload and counts its calls
+func failOn(bad interface{}, calls *int64) Processor {
+	return ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		atomic.AddInt64(calls, 1)
+		if payload == bad {
+			return nil, errors.New("bad payload")
+		}
+		return payload, nil
+	})
+}
+
+// This test checks that skipped payloads are not sent to the dead-letter sink but their errors are still handled
+func TestErrorPolicySkip(t *testing.T) {
+	skipWrites := WithStageErrorPolicy(StageWrite, SkipPayload)
+	batching := WithBatching(BatchConfig{MaxItems: 3})
+	testCases := []struct {
+		name        string
+		opts        []Option
+		writerDown  bool
+		deadLetters int
+		handled     int
+	}{
+		{name: "process default", deadLetters: 1, handled: 1},
+		{name: "process skip", opts: []Option{WithErrorPolicy(SkipPayload)}, handled: 1},
+		{name: "write default", writerDown: true, deadLetters: 3, handled: 3},
+		{name: "write skip", opts: []Option{skipWrites}, writerDown: true, handled: 3},
+		{name: "batch default", opts: []Option{batching}, writerDown: true, deadLetters: 3, handled: 1},
+		{name: "batch skip", opts: []Option{batching, skipWrites}, writerDown: true, handled: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls, handled int64
+			p := newQuietPipeline(tc.opts...)
+			p.SetErrorHandler(errorHandlerFunc(func(ctx context.Context, err error) error {
+				atomic.AddInt64(&handled, 1)
+				return err
+			}))
+			p.AddSource(&sliceSource{payloads: []interface{}{"a", "b", "c"}})
+			bad := interface{}("b")
+			if tc.writerDown {
+				bad = nil
+			}
+			p.SetProcessor(failOn(bad, &calls))
+			p.AddWriter(&flakyWriter{down: tc.writerDown}, pencode.Printer{})
+			dead := &bufferCloser{}
+			p.SetDeadLetter(dead, pencode.Printer{})
+
+			require.NoError(t, p.Run(context.Background()))
+			assert.Len(t, dead.writes, tc.deadLetters)
+			assert.Equal(t, int64(tc.handled), atomic.LoadInt64(&handled))
+		})
+	}
+}
+
+// This test checks that payloads are retried for as long as the error policy decides and that the policy receives
+// the failure
+func TestErrorPolicyRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failures []Failure
+	)
+	policy := ErrorPolicyFunc(func(ctx context.Context, f Failure) ErrorAction {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, f)
+		if f.Attempt < 3 {
+			return RetryPayload
+		}
+		return DeadLetterPayload
+	})
+	var calls int64
+	p := newQuietPipeline(WithErrorPolicy(policy))
+	p.AddSource(&sliceSource{payloads: []interface{}{"a", "b"}})
+	p.SetProcessor(failOn("b", &calls))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{"a"}, out.results)
+	assert.Equal(t, int64(4), calls)
+	require.Len(t, failures, 3)
+	for ii, f := range failures {
+		assert.Equal(t, StageProcess, f.Stage)
+		assert.Equal(t, "*generic.sliceSource", f.Reader)
+		assert.Equal(t, "b", f.Payload)
+		assert.Equal(t, ii+1, f.Attempt)
+		assert.EqualError(t, f.Err, "bad payload")
+	}
+}
+
+// This test checks that a reader stopped by the error policy stops reading while the other readers continue
+func TestErrorPolicyStopReader(t *testing.T) {
+	var calls int64
+	p := newQuietPipeline(WithStageErrorPolicy(StageProcess, StopReader))
+	p.AddSource(&sliceSource{payloads: []interface{}{"x", "x", "x"}})
+	p.AddSource(&sliceSource{payloads: numbers(5)})
+	p.SetProcessor(failOn("x", &calls))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Len(t, out.results, 5)
+	assert.Equal(t, int64(6), calls)
+}
+
+// This test checks that a reader paused by the error policy resumes reading after the pause
+func TestErrorPolicyPauseReader(t *testing.T) {
+	var calls int64
+	p := newQuietPipeline(WithErrorPolicy(PauseReader(30 * time.Millisecond)))
+	p.AddSource(&sliceSource{payloads: []interface{}{"x", "a", "b"}})
+	p.SetProcessor(failOn("x", &calls))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	start := time.Now()
+	require.NoError(t, p.Run(context.Background()))
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+	assert.Equal(t, []interface{}{"a", "b"}, out.results)
+}
+
+// This test checks that the pipeline stops once the threshold of errors is exceeded
+func TestErrorPolicyThreshold(t *testing.T) {
+	var calls int64
+	p := newQuietPipeline(WithErrorPolicy(Threshold(1, time.Minute, StopPipeline, SkipPayload)))
+	p.AddSource(&sliceSource{payloads: []interface{}{"x", "x", "x", "x"}})
+	p.SetProcessor(failOn("x", &calls))
+
+	err := p.Run(context.Background())
+	require.Error(t, err)
+	var stageErr *StageError
+	require.True(t, errors.As(err, &stageErr))
+	assert.Equal(t, StageProcess, stageErr.Stage)
+	assert.EqualError(t, stageErr.Err, "bad payload")
+	assert.Equal(t, int64(2), calls)
+}
+
+func TestThresholdWindow(t *testing.T) {
+	th := Threshold(2, time.Minute, StopPipeline, nil).(*threshold)
+	start := time.Now()
+
+	assert.Equal(t, 1, th.count(start))
+	assert.Equal(t, 2, th.count(start.Add(10*time.Second)))
+	assert.Equal(t, 3, th.count(start.Add(20*time.Second)))
+	// The first error leaves the window
+	assert.Equal(t, 3, th.count(start.Add(65*time.Second)))
+	assert.Equal(t, 1, th.count(start.Add(5*time.Minute)))
+}
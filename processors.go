@@ -0,0 +1,81 @@
+package generic
+
+import (
+	"context"
+)
+
+// Results can be returned by a Processor to emit any number of results for a single payload. Each result is
+// written separately and an empty Results writes nothing.
+type Results []interface{}
+
+// ProcessorFunc is an adapter to allow ordinary functions to be used as a Processor
+type ProcessorFunc func(ctx context.Context, payload interface{}) (interface{}, error)
+
+// Process calls f(ctx, payload)
+func (f ProcessorFunc) Process(ctx context.Context, payload interface{}) (interface{}, error) {
+	return f(ctx, payload)
+}
+
+// Map returns a Processor which transforms each payload with fn
+func Map(fn func(ctx context.Context, payload interface{}) (interface{}, error)) Processor {
+	return ProcessorFunc(fn)
+}
+
+// Filter returns a Processor which passes on the payloads for which keep returns true and drops the rest
+func Filter(keep func(ctx context.Context, payload interface{}) bool) Processor {
+	return ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if !keep(ctx, payload) {
+			return Results{}, nil
+		}
+		return payload, nil
+	})
+}
+
+// FlatMap returns a Processor which emits zero or more results for each payload
+func FlatMap(fn func(ctx context.Context, payload interface{}) ([]interface{}, error)) Processor {
+	return ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		results, err := fn(ctx, payload)
+		if err != nil {
+			return nil, err
+		}
+		return Results(results), nil
+	})
+}
+
+// Tap returns a Processor which calls fn for its side effects and passes on the payload unchanged
+func Tap(fn func(ctx context.Context, payload interface{}) error) Processor {
+	return ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if err := fn(ctx, payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	})
+}
+
+// Chain returns a Processor which passes each payload through the processors in sequence. When a processor emits
+// Results, each of them is passed to the next processor separately and a payload that is dropped is not passed on.
+// The first error stops the chain for that payload.
+func Chain(procs ...Processor) Processor {
+	return ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		payloads := Results{payload}
+		for _, proc := range procs {
+			var next Results
+			for _, v := range payloads {
+				result, err := proc.Process(ctx, v)
+				if err != nil {
+					return nil, err
+				}
+				if results, ok := result.(Results); ok {
+					next = append(next, results...)
+				} else {
+					next = append(next, result)
+				}
+			}
+			payloads = next
+		}
+		if len(payloads) == 1 {
+			return payloads[0], nil
+		}
+		return payloads, nil
+	})
+}
@@ -0,0 +1,96 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceSource returns each of its payloads and then EOF
+type sliceSource struct {
+	payloads []interface{}
+}
+
+func (s *sliceSource) Read() (interface{}, error) {
+	if len(s.payloads) == 0 {
+		return nil, EOF
+	}
+	v := s.payloads[0]
+	s.payloads = s.payloads[1:]
+	return v, nil
+}
+
+func TestChain(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		tapped []interface{}
+	)
+	proc := Chain(
+		FlatMap(func(ctx context.Context, payload interface{}) ([]interface{}, error) {
+			var words []interface{}
+			for _, w := range strings.Fields(payload.(string)) {
+				words = append(words, w)
+			}
+			return words, nil
+		}),
+		Filter(func(ctx context.Context, payload interface{}) bool {
+			return len(payload.(string)) > 1
+		}),
+		Tap(func(ctx context.Context, payload interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			tapped = append(tapped, payload)
+			return nil
+		}),
+		Map(func(ctx context.Context, payload interface{}) (interface{}, error) {
+			return strings.ToUpper(payload.(string)), nil
+		}),
+	)
+
+	for _, workers := range []int{1, 3} {
+		tapped = nil
+		p := newQuietPipeline(WithWorkers(workers, Ordered))
+		p.AddSource(&sliceSource{payloads: []interface{}{"hello a world", "b", "c is fine"}})
+		p.SetProcessor(proc)
+		w := &collectWriter{}
+		p.writers = append(p.writers, w)
+
+		require.NoError(t, p.Run(context.Background()))
+		assert.Equal(t, []interface{}{"HELLO", "WORLD", "IS", "FINE"}, w.results)
+		// Taps run on the workers, so their side effects are only ordered with a single worker
+		if workers == 1 {
+			assert.Equal(t, []interface{}{"hello", "world", "is", "fine"}, tapped)
+		} else {
+			assert.ElementsMatch(t, []interface{}{"hello", "world", "is", "fine"}, tapped)
+		}
+	}
+}
+
+func TestChainError(t *testing.T) {
+	procErr := fmt.Errorf("proc error from test")
+	var called bool
+	proc := Chain(
+		Map(func(ctx context.Context, payload interface{}) (interface{}, error) {
+			return nil, procErr
+		}),
+		Tap(func(ctx context.Context, payload interface{}) error {
+			called = true
+			return nil
+		}),
+	)
+	_, err := proc.Process(context.Background(), 1)
+	assert.Equal(t, procErr, err)
+	assert.False(t, called)
+
+	// A single result is not wrapped in Results
+	result, err := Chain(Map(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		return payload.(int) + 1, nil
+	})).Process(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result)
+}
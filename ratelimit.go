@@ -0,0 +1,192 @@
+package generic
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit configures a token bucket which allows Rate items or bytes per second, with bursts of up to Burst
+type RateLimit struct {
+	// Rate is the number of items or bytes allowed per second. Zero does not limit the rate.
+	Rate float64
+	// Burst is the number of items or bytes allowed at once. It defaults to the rate, rounded up.
+	Burst int
+	// Drop drops the items that exceed the rate instead of waiting until they are allowed
+	Drop bool
+}
+
+// ReaderOption configures a reader of the pipeline
+type ReaderOption func(cfg *readerConfig)
+
+// readerConfig holds the configuration of a reader
+type readerConfig struct {
+	limit *tokenBucket
+}
+
+// ReadRate limits the number of payloads per second read from the reader
+func ReadRate(limit RateLimit) ReaderOption {
+	return func(cfg *readerConfig) {
+		cfg.limit = newTokenBucket(limit)
+	}
+}
+
+// WriteRate limits the number of results per second written to the writer
+func WriteRate(limit RateLimit) WriterOption {
+	return func(w *routedWriter) {
+		w.items = newTokenBucket(limit)
+	}
+}
+
+// WriteBytesRate limits the number of bytes per second written to the writer. The bytes of each write are counted
+// once it completes, so a write larger than the burst delays the writes after it.
+func WriteBytesRate(limit RateLimit) WriterOption {
+	return func(w *routedWriter) {
+		w.bytes = newTokenBucket(limit)
+	}
+}
+
+// WithRateLimit limits the number of payloads per second read by the pipeline across all of its readers
+func WithRateLimit(limit RateLimit) Option {
+	return func(p *Pipeline) {
+		p.limit = newTokenBucket(limit)
+	}
+}
+
+// addReader adds a reader to the pipeline with the given options. The reader lock must be held.
+func (p *Pipeline) addReader(r pipeReader, opts []ReaderOption) {
+	p.readers = append(p.readers, r)
+	if len(opts) == 0 {
+		return
+	}
+	var cfg readerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if p.readerLimits == nil {
+		p.readerLimits = make(map[pipeReader]*tokenBucket)
+	}
+	p.readerLimits[r] = cfg.limit
+}
+
+// admit waits until the rate limits of the pipeline and the reader allow another payload. It returns false if the
+// payload was dropped by a rate limit or the context was canceled while waiting.
+func (p *Pipeline) admit(ctx context.Context, r pipeReader) bool {
+	p.readerLock.Lock()
+	readerLimit := p.readerLimits[r]
+	p.readerLock.Unlock()
+
+	for _, limit := range []*tokenBucket{readerLimit, p.limit} {
+		ok, err := limit.take(ctx, 1)
+		if err != nil {
+			return false
+		}
+		if !ok {
+			atomic.AddUint64(&p.dropped, 1)
+			return false
+		}
+	}
+	return true
+}
+
+// writeLimited writes the given number of results to the writer with fn once the rate limits of the writer allow it.
+// It returns false if the write was dropped by a rate limit.
+func (p *Pipeline) writeLimited(ctx context.Context, w pipeWriter, items int, fn func() (int, error)) (bool, error) {
+	rw, ok := w.(*routedWriter)
+	if !ok {
+		_, err := fn()
+		return true, err
+	}
+	// Bytes are charged once the size of the write is known, so only wait for the byte limit to be out of debt
+	for _, take := range []struct {
+		limit *tokenBucket
+		n     float64
+	}{{rw.items, float64(items)}, {rw.bytes, 0}} {
+		ok, err := take.limit.take(ctx, take.n)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			atomic.AddUint64(&p.dropped, uint64(items))
+			return false, nil
+		}
+	}
+	n, err := fn()
+	rw.bytes.charge(n)
+	return true, err
+}
+
+// tokenBucket is a rate limiter which holds up to burst tokens and is refilled at a constant rate. A nil tokenBucket
+// does not limit the rate.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	drop  bool
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(limit.Rate)
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, drop: limit.Drop, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last refill. The lock must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take waits until n tokens are available and takes them. Taking zero tokens waits for the bucket to be out of debt.
+// Since the bucket never holds more than burst tokens, taking more waits for a full bucket and charges the rest as
+// debt. It returns false without waiting if the bucket drops instead of blocking.
+func (b *tokenBucket) take(ctx context.Context, n float64) (bool, error) {
+	if b == nil {
+		return true, nil
+	}
+	need := math.Min(n, b.burst)
+	for {
+		b.mu.Lock()
+		b.refill(time.Now())
+		if b.tokens >= need {
+			b.tokens -= n
+			b.mu.Unlock()
+			return true, nil
+		}
+		if b.drop {
+			b.mu.Unlock()
+			return false, nil
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// charge takes n tokens after the fact, which may leave the bucket in debt
+func (b *tokenBucket) charge(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+}
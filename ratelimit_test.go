@@ -0,0 +1,92 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+func numbers(n int) []interface{} {
+	var payloads []interface{}
+	for ii := 0; ii < n; ii++ {
+		payloads = append(payloads, ii)
+	}
+	return payloads
+}
+
+func TestReadRate(t *testing.T) {
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: numbers(11)}, ReadRate(RateLimit{Rate: 200, Burst: 1}))
+	p.SetProcessor(identity)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	start := time.Now()
+	require.NoError(t, p.Run(context.Background()))
+	assert.True(t, time.Since(start) >= 45*time.Millisecond)
+	assert.Len(t, out.results, 11)
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	p := newQuietPipeline(WithRateLimit(RateLimit{Rate: 1, Burst: 2, Drop: true}))
+	p.AddSource(&sliceSource{payloads: numbers(5)})
+	p.SetProcessor(identity)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{0, 1}, out.results)
+	assert.Equal(t, uint64(3), p.Dropped())
+}
+
+func TestWriteBytesRate(t *testing.T) {
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: []interface{}{"0123456789", "0123456789", "0123456789", "0123456789"}})
+	p.SetProcessor(identity)
+	out := &bufferCloser{}
+	p.AddWriter(out, pencode.Printer{}, WriteBytesRate(RateLimit{Rate: 1000, Burst: 10}))
+
+	start := time.Now()
+	require.NoError(t, p.Run(context.Background()))
+	// The last write waits for the 20 bytes written before it in excess of the burst
+	assert.True(t, time.Since(start) >= 18*time.Millisecond)
+	assert.Len(t, out.writes, 4)
+}
+
+// This test checks that batches larger than the burst of a write rate are written once the bucket is full and leave
+// it in debt
+func TestWriteRateBatches(t *testing.T) {
+	p := newBatchedPipeline(BatchConfig{MaxItems: 20}, numbers(40)...)
+	out := &bufferCloser{}
+	p.AddWriter(out, pencode.Printer{}, WriteRate(RateLimit{Rate: 1000, Burst: 5}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, p.Run(ctx))
+	// The second batch waits for the 15 results written before it in excess of the burst and for a full bucket
+	assert.True(t, time.Since(start) >= 18*time.Millisecond)
+	assert.Len(t, out.writes, 40)
+}
+
+// This test checks that a pipeline waiting on a rate limit stops when its context is canceled
+func TestRateLimitCanceled(t *testing.T) {
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: numbers(3)}, ReadRate(RateLimit{Rate: 0.001}))
+	p.SetProcessor(identity)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := p.Run(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, []interface{}{0}, out.results)
+}
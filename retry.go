@@ -0,0 +1,60 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how a failed stage of the pipeline is re-executed when it returns a Temporary error.
+// Backoff between attempts grows exponentially from InitialBackoff by Multiplier (2 if unset) up to MaxBackoff
+// (unbounded if unset). Jitter is the fraction of each backoff, between 0 and 1, that is randomized.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+// backoff returns the duration to wait after the given (1-based) failed attempt
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := r.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	d := float64(r.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if r.MaxBackoff > 0 && d > float64(r.MaxBackoff) {
+		d = float64(r.MaxBackoff)
+	}
+	if r.Jitter > 0 {
+		jitter := math.Min(r.Jitter, 1)
+		d = d*(1-jitter) + d*jitter*rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// do executes fn until it succeeds, returns an error which is not Temporary, runs out of attempts or the context
+// is canceled. The last error returned by fn is returned.
+func (r RetryPolicy) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= r.MaxAttempts || !isTemporary(err) {
+			return err
+		}
+		select {
+		case <-time.After(r.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// isTemporary returns true if any error in the chain reports itself as Temporary
+func isTemporary(err error) bool {
+	var t Temporary
+	return errors.As(err, &t) && t.Temporary()
+}
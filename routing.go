@@ -0,0 +1,144 @@
+package generic
+
+import (
+	"sync/atomic"
+)
+
+// RouteMode determines how many of the matching writers receive a result
+type RouteMode int
+
+const (
+	// AllMatches writes each result to every writer that matches it
+	AllMatches RouteMode = iota
+	// FirstMatch writes each result to the first matching writer, in the order the writers were added
+	FirstMatch
+)
+
+// Router selects which writers receive each result
+type Router struct {
+	Mode RouteMode
+	// Key returns the routing key of a result, which is matched against the keys of writers added with Route
+	Key func(result interface{}) string
+}
+
+// WriterOption configures which results are written to a writer and how fast
+type WriterOption func(w *routedWriter)
+
+// When only writes the results for which the predicate returns true to the writer
+func When(pred func(result interface{}) bool) WriterOption {
+	return func(w *routedWriter) {
+		w.pred = pred
+	}
+}
+
+// Route only writes the results whose routing key, as returned by Router.Key, is one of the given keys
+func Route(keys ...string) WriterOption {
+	return func(w *routedWriter) {
+		w.keys = make(map[string]struct{}, len(keys))
+		for _, k := range keys {
+			w.keys[k] = struct{}{}
+		}
+	}
+}
+
+// DefaultRoute only writes the results that are not matched by any other writer
+func DefaultRoute() WriterOption {
+	return func(w *routedWriter) {
+		w.isDefault = true
+	}
+}
+
+// routedWriter is a pipeWriter which only receives the results it matches
+type routedWriter struct {
+	pipeWriter
+	pred      func(result interface{}) bool
+	keys      map[string]struct{}
+	isDefault bool
+	// items and bytes limit the rate of writes
+	items *tokenBucket
+	bytes *tokenBucket
+	// breaker skips the writer while it keeps failing and diverts its results to the fallback
+	breaker  *breaker
+	fallback pipeWriter
+}
+
+// Close closes the writer and its fallback
+func (w *routedWriter) Close() error {
+	err := w.pipeWriter.Close()
+	if w.fallback != nil {
+		if fallbackErr := w.fallback.Close(); err == nil {
+			err = fallbackErr
+		}
+	}
+	return err
+}
+
+// matches returns true if the result should be written to the writer
+func (w *routedWriter) matches(router Router, result interface{}) bool {
+	if w.pred != nil && !w.pred(result) {
+		return false
+	}
+	if w.keys != nil {
+		if router.Key == nil {
+			return false
+		}
+		if _, ok := w.keys[router.Key(result)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRouter sets the router that selects the writers for each result
+func (p *Pipeline) SetRouter(r Router) {
+	p.router = r
+}
+
+// Unrouted returns the number of results that were not written because they did not match any writer
+func (p *Pipeline) Unrouted() uint64 {
+	return atomic.LoadUint64(&p.unrouted)
+}
+
+// route returns the writers that the result should be written to. Joined pipelines receive every result and are
+// not counted as matching writers.
+func (p *Pipeline) route(result interface{}) []pipeWriter {
+	if len(p.writers) == 0 {
+		return nil
+	}
+	payload := payloadOf(result)
+
+	var targets, defaults, joined []pipeWriter
+	for _, w := range p.writers {
+		rw, ok := w.(*routedWriter)
+		switch {
+		case joins(w):
+			joined = append(joined, w)
+			continue
+		case ok && rw.isDefault:
+			defaults = append(defaults, w)
+			continue
+		case ok && !rw.matches(p.router, payload):
+			continue
+		case p.router.Mode == FirstMatch && len(targets) > 0:
+			continue
+		}
+		targets = append(targets, w)
+	}
+
+	if len(targets) == 0 {
+		targets = defaults
+	}
+	if len(targets) == 0 && len(joined) == 0 {
+		atomic.AddUint64(&p.unrouted, 1)
+	}
+	return append(targets, joined...)
+}
+
+// joins returns true if the writer feeds the results to joined pipelines
+func joins(w pipeWriter) bool {
+	switch w.(type) {
+	case *coupler, *roundRobin, *partitioner:
+		return true
+	}
+	return false
+}
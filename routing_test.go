@@ -0,0 +1,97 @@
+package generic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	region string
+	err    bool
+}
+
+func addRoutedWriter(p *Pipeline, opts ...WriterOption) *collectWriter {
+	w := &collectWriter{}
+	rw := &routedWriter{pipeWriter: w}
+	for _, opt := range opts {
+		opt(rw)
+	}
+	p.writers = append(p.writers, rw)
+	return w
+}
+
+func TestRouting(t *testing.T) {
+	records := []interface{}{
+		record{region: "eu"}, record{region: "us", err: true}, record{region: "us"}, record{region: "eu", err: true},
+	}
+	region := func(result interface{}) string { return result.(record).region }
+	isErr := func(result interface{}) bool { return result.(record).err }
+
+	testCases := []struct {
+		mode                        RouteMode
+		errs, eu, fallback, unknown []interface{}
+	}{
+		{
+			mode: AllMatches,
+			errs: []interface{}{records[1], records[3]},
+			eu:   []interface{}{records[0], records[3]},
+			// Only records matched by no other writer go to the default route
+			fallback: []interface{}{records[2]},
+		},
+		{
+			mode:     FirstMatch,
+			errs:     []interface{}{records[1], records[3]},
+			eu:       []interface{}{records[0]},
+			fallback: []interface{}{records[2]},
+		},
+	}
+
+	for _, tc := range testCases {
+		p := newQuietPipeline()
+		p.SetRouter(Router{Mode: tc.mode, Key: region})
+		p.AddSource(&sliceSource{payloads: records})
+		p.SetProcessor(Map(func(ctx context.Context, payload interface{}) (interface{}, error) {
+			return payload, nil
+		}))
+		errs := addRoutedWriter(p, When(isErr))
+		eu := addRoutedWriter(p, Route("eu"))
+		fallback := addRoutedWriter(p, DefaultRoute())
+		unknown := addRoutedWriter(p, Route("ap"))
+
+		require.NoError(t, p.Run(context.Background()))
+		assert.Equal(t, tc.errs, errs.results)
+		assert.Equal(t, tc.eu, eu.results)
+		assert.Equal(t, tc.fallback, fallback.results)
+		assert.Empty(t, unknown.results)
+		assert.Equal(t, uint64(0), p.Unrouted())
+	}
+}
+
+func TestUnrouted(t *testing.T) {
+	p := newQuietPipeline()
+	w := addRoutedWriter(p, When(func(result interface{}) bool { return result.(int) > 1 }))
+	assert.Len(t, p.route(1), 0)
+	assert.Equal(t, []pipeWriter{p.writers[0]}, p.route(2))
+	assert.Equal(t, uint64(1), p.Unrouted())
+	assert.Empty(t, w.results)
+}
+
+// This test checks that joined pipelines receive every result regardless of the routing mode
+func TestRoutingJoined(t *testing.T) {
+	for _, mode := range []RouteMode{AllMatches, FirstMatch} {
+		up, downs, writers := newJoinedPipelines(4, 1)
+		up.SetRouter(Router{Mode: mode})
+		odd := addRoutedWriter(up, When(func(result interface{}) bool { return result.(int)%2 == 1 }))
+		fallback := addRoutedWriter(up, DefaultRoute())
+		up.Join(downs[0])
+
+		require.NoError(t, Run(context.Background(), up, downs[0]))
+		assert.Equal(t, []interface{}{1, 3}, odd.results)
+		assert.Equal(t, []interface{}{0, 2}, fallback.results)
+		assert.Equal(t, []interface{}{0, 1, 2, 3}, writers[0].results)
+		assert.Equal(t, uint64(0), up.Unrouted())
+	}
+}
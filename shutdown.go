@@ -0,0 +1,60 @@
+package generic
+
+import (
+	"context"
+)
+
+// Shutdown gracefully stops the pipeline. It stops reading new input, lets the payloads already read finish
+// processing and writing, and then waits for Run to close the writers. Pipelines joined downstream are then shut down
+// in turn, after they have consumed everything written to them. If the context expires before the pipelines have
+// drained, the remaining pipelines stop immediately, abandoning in-flight payloads, and the context error is
+// returned. Shutdown must be called while Run is running.
+//
+// Joined pipelines keep reading from their upstream pipelines until those are finished, so shutting down a pipeline
+// that is fed by Join waits for its upstream pipelines to finish.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	p.log.Println("Shutting down pipeline")
+	p.drainOnce.Do(func() { close(p.draining) })
+
+	select {
+	case <-p.finished:
+	case <-ctx.Done():
+		p.abort()
+		return ctx.Err()
+	}
+
+	for _, out := range p.downstream {
+		if err := out.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort stops the pipeline and all the pipelines downstream of it without waiting for in-flight payloads
+func (p *Pipeline) abort() {
+	p.abortOnce.Do(func() { close(p.aborted) })
+	for _, out := range p.downstream {
+		out.abort()
+	}
+}
+
+// isDraining returns true once Shutdown has been called
+func (p *Pipeline) isDraining() bool {
+	select {
+	case <-p.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainSignal returns the channel that is closed when the reader should stop reading new input. Couplers and
+// mergers are read until their upstream pipelines close them so that everything written to them is processed.
+func (p *Pipeline) drainSignal(r pipeReader) <-chan struct{} {
+	switch r.(type) {
+	case *coupler, *merger:
+		return nil
+	}
+	return p.draining
+}
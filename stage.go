@@ -0,0 +1,38 @@
+package generic
+
+// Stage identifies a step that a payload goes through in the pipeline
+type Stage int
+
+const (
+	// StageRead is the reading of raw input from a reader
+	StageRead Stage = iota
+	// StageDecode is the decoding of raw input into a payload
+	StageDecode
+	// StageProcess is the processing of a payload by the Processor
+	StageProcess
+	// StageEncode is the encoding of a result into raw output
+	StageEncode
+	// StageWrite is the writing of a result to a writer
+	StageWrite
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageRead:
+		return "read"
+	case StageDecode:
+		return "decode"
+	case StageProcess:
+		return "process"
+	case StageEncode:
+		return "encode"
+	case StageWrite:
+		return "write"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the stage as its name
+func (s Stage) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
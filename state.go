@@ -0,0 +1,213 @@
+package generic
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is a keyed store for the state of stateful processors, such as counters or the keys seen so far. It is made
+// available to processors through the context with StateFromContext.
+type State interface {
+	// Get returns the value of the key and whether it exists and has not expired
+	Get(key string) (interface{}, bool)
+	// Put sets the value of the key
+	Put(key string, value interface{})
+	// PutWithTTL sets the value of the key, which expires after the ttl
+	PutWithTTL(key string, value interface{}, ttl time.Duration)
+	// Delete removes the key
+	Delete(key string)
+}
+
+// PersistentState is a State which can be saved and restored so that a restarted pipeline resumes with its state
+type PersistentState interface {
+	State
+	// Restore loads the saved state. It is called when the pipeline starts running.
+	Restore() error
+	// Snapshot saves the state. It is called periodically while the pipeline runs and when it stops.
+	Snapshot() error
+}
+
+type stateContextKey struct{}
+
+// StateFromContext returns the state of the pipeline from the context passed to the processor, or nil if the
+// pipeline has no state
+func StateFromContext(ctx context.Context) State {
+	s, _ := ctx.Value(stateContextKey{}).(State)
+	return s
+}
+
+// withState returns a copy of the context carrying the state
+func withState(ctx context.Context, s State) context.Context {
+	return context.WithValue(ctx, stateContextKey{}, s)
+}
+
+// WithSnapshots sets how often the persistent state of the pipeline is saved while it runs. The state is always saved
+// when the pipeline stops.
+func WithSnapshots(interval time.Duration) Option {
+	return func(p *Pipeline) {
+		p.snapshotInterval = interval
+	}
+}
+
+// SetState sets the state that the processor accesses through its context. If the state is a PersistentState, it is
+// restored when the pipeline starts running and saved periodically and when it stops.
+func (p *Pipeline) SetState(s State) {
+	p.state = s
+}
+
+// startState restores the persistent state and periodically saves it until the returned function is called, which
+// saves the final state. A state that fails to restore stops the pipeline.
+func (p *Pipeline) startState() (stop func()) {
+	ps, ok := p.state.(PersistentState)
+	if !ok {
+		return func() {}
+	}
+	if err := ps.Restore(); err != nil {
+		p.log.Error("Error restoring state: %s", err)
+		p.stop(NewFatalError(fmt.Errorf("restoring state: %w", err)))
+		return func() {}
+	}
+	stopTicker := p.every(p.snapshotInterval, func(time.Time) {
+		if err := ps.Snapshot(); err != nil {
+			p.log.Error("Error saving state: %s", err)
+		}
+	})
+	return func() {
+		stopTicker()
+		if err := ps.Snapshot(); err != nil {
+			p.log.Error("Error saving state: %s", err)
+		}
+	}
+}
+
+// stateEntry is a value in the state along with its expiry time, which is zero if it does not expire
+type stateEntry struct {
+	Value   interface{}
+	Expires time.Time
+}
+
+func (e stateEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// MemoryState is a State held in memory. It is safe for concurrent use.
+type MemoryState struct {
+	mu      sync.Mutex
+	entries map[string]stateEntry
+}
+
+// NewMemoryState creates an empty MemoryState
+func NewMemoryState() *MemoryState {
+	return &MemoryState{entries: make(map[string]stateEntry)}
+}
+
+// Get returns the value of the key and whether it exists and has not expired
+func (s *MemoryState) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// Put sets the value of the key
+func (s *MemoryState) Put(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = stateEntry{Value: value}
+}
+
+// PutWithTTL sets the value of the key, which expires after the ttl
+func (s *MemoryState) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = stateEntry{Value: value, Expires: time.Now().Add(ttl)}
+}
+
+// Delete removes the key
+func (s *MemoryState) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// live returns a copy of the entries which have not expired, removing the expired ones
+func (s *MemoryState) live() map[string]stateEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	entries := make(map[string]stateEntry, len(s.entries))
+	for key, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, key)
+			continue
+		}
+		entries[key] = e
+	}
+	return entries
+}
+
+// FileState is a MemoryState which is saved to and restored from a file. Values are encoded with encoding/gob, so
+// values of custom types must be registered with gob.Register.
+type FileState struct {
+	*MemoryState
+	path string
+}
+
+// NewFileState creates a FileState saved to the file at path
+func NewFileState(path string) *FileState {
+	return &FileState{MemoryState: NewMemoryState(), path: path}
+}
+
+// Restore loads the state from the file. A missing file restores an empty state.
+func (s *FileState) Restore() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := make(map[string]stateEntry)
+	if err = gob.NewDecoder(f).Decode(&entries); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+	return nil
+}
+
+// Snapshot saves the state to the file. The state is written to a temporary file first so that the previous snapshot
+// is kept if writing fails.
+func (s *FileState) Snapshot() error {
+	entries := s.live()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,76 @@
+package generic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryState(t *testing.T) {
+	s := NewMemoryState()
+	s.Put("a", 1)
+	s.PutWithTTL("b", 2, time.Millisecond)
+
+	v, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	time.Sleep(2 * time.Millisecond)
+	_, ok = s.Get("b")
+	assert.False(t, ok, "expired keys are not returned")
+
+	s.Delete("a")
+	_, ok = s.Get("a")
+	assert.False(t, ok)
+}
+
+// countByKey counts the payloads seen for each key in the state of the pipeline
+var countByKey = ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+	state := StateFromContext(ctx)
+	key := payload.(string)
+	n, _ := state.Get(key)
+	count, _ := n.(int)
+	state.Put(key, count+1)
+	return count + 1, nil
+})
+
+func runCounter(t *testing.T, path string, payloads ...interface{}) []interface{} {
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: payloads})
+	p.SetProcessor(countByKey)
+	p.SetState(NewFileState(path))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+	require.NoError(t, p.Run(context.Background()))
+	return out.results
+}
+
+// This test checks that a restarted pipeline resumes with the state saved when it last stopped
+func TestFileStateRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+
+	assert.Equal(t, []interface{}{1, 1, 2}, runCounter(t, path, "a", "b", "a"))
+	assert.Equal(t, []interface{}{3, 2}, runCounter(t, path, "a", "b"))
+}
+
+func TestFileStateRestoreError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, os.WriteFile(path, []byte("not a snapshot"), 0600))
+
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: []interface{}{"a"}})
+	p.SetProcessor(countByKey)
+	p.SetState(NewFileState(path))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	err := p.Run(context.Background())
+	require.Error(t, err)
+	assert.True(t, isFatal(err))
+	assert.Empty(t, out.results)
+}
@@ -0,0 +1,124 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimeoutConfig configures the deadline for processing each payload and the detection of slow payloads
+type TimeoutConfig struct {
+	// Timeout is the deadline for processing a single payload. Each retry gets a new deadline.
+	// Zero does not limit the processing time.
+	Timeout time.Duration
+	// Temporary makes timeouts Temporary errors, which are retried by the retry policy of the pipeline
+	Temporary bool
+	// Fatal makes timeouts Fatal errors, which stop the pipeline
+	Fatal bool
+	// SlowThreshold is the processing time after which a payload is reported as slow. Zero disables the reports.
+	SlowThreshold time.Duration
+	// OnSlow is called when a payload has been processing for longer than the SlowThreshold, while it is still
+	// processing. It defaults to logging a warning.
+	OnSlow func(ctx context.Context, payload interface{}, elapsed time.Duration)
+}
+
+// WithTimeout sets a deadline for processing each payload. The processor receives a context derived from the
+// pipeline context which is canceled at the deadline. If the processor has not returned by then, the payload fails
+// with a TimeoutError and the reader moves on without waiting for the processor.
+func WithTimeout(cfg TimeoutConfig) Option {
+	return func(p *Pipeline) {
+		p.timeouts = &cfg
+	}
+}
+
+// TimeoutError is the error of a payload which was not processed before its deadline. Whether it is Temporary or
+// Fatal is configured by the TimeoutConfig of the pipeline.
+type TimeoutError struct {
+	// Stage is the stage of the pipeline which timed out
+	Stage Stage
+	// Timeout is the deadline which was exceeded
+	Timeout time.Duration
+	// Payload is the payload which timed out
+	Payload interface{}
+
+	temporary bool
+	fatal     bool
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Stage, e.Timeout)
+}
+
+// Unwrap returns context.DeadlineExceeded
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Temporary indicates whether the timeout is retried
+func (e *TimeoutError) Temporary() bool {
+	return e.temporary
+}
+
+// Fatal indicates whether the timeout stops the pipeline
+func (e *TimeoutError) Fatal() bool {
+	return e.fatal
+}
+
+// processOnce passes the payload to the processor within the deadline of the pipeline, reporting slow payloads
+func (p *Pipeline) processOnce(ctx context.Context, payload interface{}) (interface{}, error) {
+	cfg := p.timeouts
+	if cfg == nil {
+		return p.callProcessor(ctx, payload)
+	}
+	if cfg.SlowThreshold > 0 {
+		start := time.Now()
+		slow := time.AfterFunc(cfg.SlowThreshold, func() {
+			p.slowPayload(ctx, payload, time.Since(start))
+		})
+		defer slow.Stop()
+	}
+	if cfg.Timeout <= 0 {
+		return p.callProcessor(ctx, payload)
+	}
+
+	deadlineCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := p.callProcessor(deadlineCtx, payload)
+		done <- outcome{result: result, err: err}
+	}()
+
+	timeoutErr := &TimeoutError{Stage: StageProcess, Timeout: cfg.Timeout, Payload: payload,
+		temporary: cfg.Temporary, fatal: cfg.Fatal}
+	select {
+	case out := <-done:
+		// Processors that respect their context return its error once the deadline passes
+		if errors.Is(out.err, context.DeadlineExceeded) && deadlineCtx.Err() == context.DeadlineExceeded &&
+			ctx.Err() == nil {
+			return nil, timeoutErr
+		}
+		return out.result, out.err
+	case <-deadlineCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p.log.Error("Abandoning processor: %s", timeoutErr)
+		return nil, timeoutErr
+	}
+}
+
+// slowPayload reports a payload which has been processing for longer than the slow threshold
+func (p *Pipeline) slowPayload(ctx context.Context, payload interface{}, elapsed time.Duration) {
+	if p.timeouts.OnSlow != nil {
+		p.timeouts.OnSlow(ctx, payload, elapsed)
+		return
+	}
+	p.log.Printf("Slow payload has been processing for %s: %v", elapsed, payload)
+}
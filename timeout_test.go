@@ -0,0 +1,111 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangOn returns a processor which ignores its context and never returns for the given payload
+func hangOn(hung interface{}) Processor {
+	return ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if payload == hung {
+			select {}
+		}
+		return payload, nil
+	})
+}
+
+func TestProcessTimeout(t *testing.T) {
+	var timeouts []*TimeoutError
+	p := newQuietPipeline(WithTimeout(TimeoutConfig{Timeout: 10 * time.Millisecond}))
+	p.SetErrorHandler(errorHandlerFunc(func(ctx context.Context, err error) error {
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			timeouts = append(timeouts, timeoutErr)
+		}
+		return err
+	}))
+	p.AddSource(&sliceSource{payloads: []interface{}{1, 2, 3}})
+	p.SetProcessor(hangOn(2))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{1, 3}, out.results)
+	require.Len(t, timeouts, 1)
+	assert.Equal(t, 2, timeouts[0].Payload)
+	assert.True(t, errors.Is(timeouts[0], context.DeadlineExceeded))
+}
+
+// This test checks that Temporary timeouts are retried with a new deadline
+func TestProcessTimeoutRetry(t *testing.T) {
+	var attempts int32
+	p := newQuietPipeline(
+		WithTimeout(TimeoutConfig{Timeout: 10 * time.Millisecond, Temporary: true}),
+		WithRetry(RetryPolicy{MaxAttempts: 2}),
+	)
+	p.AddSource(&sliceSource{payloads: []interface{}{1}})
+	p.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return payload, nil
+	}))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{1}, out.results)
+	assert.Equal(t, int32(2), attempts)
+}
+
+func TestProcessTimeoutFatal(t *testing.T) {
+	p := newQuietPipeline(WithTimeout(TimeoutConfig{Timeout: 10 * time.Millisecond, Fatal: true}))
+	p.AddSource(&sliceSource{payloads: []interface{}{1, 2, 3}})
+	p.SetProcessor(hangOn(1))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	err := p.Run(context.Background())
+	require.Error(t, err)
+	assert.True(t, isFatal(err))
+	assert.Empty(t, out.results)
+}
+
+func TestSlowPayload(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		slow []interface{}
+	)
+	p := newQuietPipeline(WithTimeout(TimeoutConfig{
+		SlowThreshold: 5 * time.Millisecond,
+		OnSlow: func(ctx context.Context, payload interface{}, elapsed time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			slow = append(slow, payload)
+		},
+	}))
+	p.AddSource(&sliceSource{payloads: []interface{}{1, 2, 3}})
+	p.SetProcessor(ProcessorFunc(func(ctx context.Context, payload interface{}) (interface{}, error) {
+		if payload == 2 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return payload, nil
+	}))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{1, 2, 3}, out.results)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{2}, slow)
+}
@@ -0,0 +1,87 @@
+package typed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Encoder is the type-safe version of pencode.Encoder
+type Encoder[T any] interface {
+	Encode(v T) ([]byte, error)
+}
+
+// Decoder is the type-safe version of pencode.Decoder
+type Decoder[T any] interface {
+	Decode(b []byte) (T, error)
+}
+
+// decoderAdapter adapts a typed Decoder to pencode.Decoder
+type decoderAdapter[T any] struct {
+	dec Decoder[T]
+}
+
+func (a decoderAdapter[T]) Decode(b []byte) (interface{}, error) {
+	return a.dec.Decode(b)
+}
+
+// encoderAdapter adapts a typed Encoder to pencode.Encoder
+type encoderAdapter[T any] struct {
+	enc Encoder[T]
+}
+
+func (a encoderAdapter[T]) Encode(v interface{}) ([]byte, error) {
+	typed, ok := v.(T)
+	if !ok {
+		var expected T
+		return nil, fmt.Errorf("encoder expects %T value but instead got %T", expected, v)
+	}
+	return a.enc.Encode(typed)
+}
+
+// JSONDecoder decodes the byte payload into a value of type T
+type JSONDecoder[T any] struct {
+	Strict bool
+}
+
+// Decode decodes the payload into a new T
+func (d JSONDecoder[T]) Decode(b []byte) (T, error) {
+	var v T
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if d.Strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// JSONEncoder encodes values of type T into json payloads
+type JSONEncoder[T any] struct{}
+
+// Encode encodes the value into a []byte payload
+func (e JSONEncoder[T]) Encode(v T) ([]byte, error) {
+	writer := bytes.Buffer{}
+	err := json.NewEncoder(&writer).Encode(v)
+	return writer.Bytes(), err
+}
+
+// PassThrough is both an encoder and decoder of []byte which does not alter the payload
+type PassThrough struct{}
+
+// Decode does nothing with the data going through
+func (PassThrough) Decode(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+// Encode does nothing with the data going through
+func (PassThrough) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+// Printer is an encoder that uses fmt.Sprint to encode values of type T
+type Printer[T any] struct{}
+
+// Encode encodes the payload into []byte using fmt.Sprint
+func (Printer[T]) Encode(v T) ([]byte, error) {
+	return []byte(fmt.Sprint(v)), nil
+}
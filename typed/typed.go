@@ -0,0 +1,100 @@
+// Package typed provides a type-safe API on top of the interface{} based pipeline. Payloads still flow through the
+// untyped core, but decoders, processors and encoders are checked at compile time so that type mismatches between
+// stages become compile errors instead of runtime panics.
+package typed
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	generic "github.com/lobocv/pipeline"
+)
+
+// Processor is the type-safe version of generic.Processor
+type Processor[In, Out any] interface {
+	Process(ctx context.Context, payload In) (Out, error)
+}
+
+// ProcessorFunc is an adapter to allow ordinary functions to be used as a Processor
+type ProcessorFunc[In, Out any] func(ctx context.Context, payload In) (Out, error)
+
+// Process calls f(ctx, payload)
+func (f ProcessorFunc[In, Out]) Process(ctx context.Context, payload In) (Out, error) {
+	return f(ctx, payload)
+}
+
+// Pipeline is a type-safe wrapper around generic.Pipeline that reads payloads of type In and writes results of
+// type Out
+type Pipeline[In, Out any] struct {
+	p *generic.Pipeline
+}
+
+// NewPipeline creates a new typed pipeline with the given processor, configured with the options of
+// generic.NewPipeline
+func NewPipeline[In, Out any](proc Processor[In, Out], opts ...generic.Option) *Pipeline[In, Out] {
+	p := generic.NewPipeline(opts...)
+	p.SetProcessor(processorAdapter[In, Out]{proc: proc})
+	return &Pipeline[In, Out]{p: p}
+}
+
+// AddMessageSource appends a MessageReader to the input of this pipeline
+func (p *Pipeline[In, Out]) AddMessageSource(r generic.MessageReader, dec Decoder[In], opts ...generic.ReaderOption) {
+	p.p.AddMessageSource(r, decoderAdapter[In]{dec: dec}, opts...)
+}
+
+// AddReader appends an io.Reader to the input of this pipeline
+func (p *Pipeline[In, Out]) AddReader(r io.Reader, dec Decoder[In], buf []byte, opts ...generic.ReaderOption) {
+	p.p.AddReader(r, decoderAdapter[In]{dec: dec}, buf, opts...)
+}
+
+// AddWriter appends a io.Writer to the output of this pipeline. See generic.Pipeline.AddWriter for the options.
+func (p *Pipeline[In, Out]) AddWriter(w io.WriteCloser, enc Encoder[Out], opts ...generic.WriterOption) {
+	p.p.AddWriter(w, encoderAdapter[Out]{enc: enc}, opts...)
+}
+
+// Untyped returns the underlying untyped pipeline. This gives access to the rest of the pipeline configuration
+// such as the logger and error handler.
+func (p *Pipeline[In, Out]) Untyped() *generic.Pipeline {
+	return p.p
+}
+
+// Run is a blocking call that engages the pipeline. See generic.Pipeline.Run for the returned error.
+func (p *Pipeline[In, Out]) Run(ctx context.Context) error {
+	return p.p.Run(ctx)
+}
+
+// Join joins the output of the upstream pipeline to the input of the downstream pipeline. It only compiles when the
+// output type of the upstream pipeline matches the input type of the downstream pipeline.
+func Join[In, Mid, Out any](up *Pipeline[In, Mid], down *Pipeline[Mid, Out]) {
+	up.p.Join(down.p)
+}
+
+// Stage is implemented by all typed pipelines regardless of their type parameters
+type Stage interface {
+	Untyped() *generic.Pipeline
+}
+
+// Run engages all the provided pipelines and blocks until they have all finished running. See generic.Run for the
+// returned error.
+func Run(ctx context.Context, stages ...Stage) error {
+	pipelines := make([]*generic.Pipeline, 0, len(stages))
+	for _, s := range stages {
+		pipelines = append(pipelines, s.Untyped())
+	}
+	return generic.Run(ctx, pipelines...)
+}
+
+// processorAdapter adapts a typed Processor to generic.Processor
+type processorAdapter[In, Out any] struct {
+	proc Processor[In, Out]
+}
+
+func (a processorAdapter[In, Out]) Process(ctx context.Context, payload interface{}) (interface{}, error) {
+	v, ok := payload.(In)
+	if !ok {
+		var expected In
+		return nil, fmt.Errorf("processor expects %T value but instead got %T", expected, payload)
+	}
+	return a.proc.Process(ctx, v)
+}
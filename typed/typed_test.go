@@ -0,0 +1,87 @@
+package typed
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	generic "github.com/lobocv/pipeline"
+)
+
+type sliceReader struct {
+	msgs [][]byte
+}
+
+func (r *sliceReader) Read() ([]byte, error) {
+	if len(r.msgs) == 0 {
+		return nil, generic.EOF
+	}
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return msg, nil
+}
+
+type person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestJoin(t *testing.T) {
+	reader := &sliceReader{msgs: [][]byte{[]byte(`{"name": "bob", "age": 30}`), []byte(`{"name": "alice", "age": 40}`)}}
+
+	older := ProcessorFunc[person, person](func(ctx context.Context, p person) (person, error) {
+		p.Age++
+		return p, nil
+	})
+	names := ProcessorFunc[person, string](func(ctx context.Context, p person) (string, error) {
+		return strings.ToUpper(p.Name), nil
+	})
+
+	p1 := NewPipeline[person, person](older)
+	p1.AddMessageSource(reader, JSONDecoder[person]{Strict: true})
+	out1 := bytes.Buffer{}
+	p1.AddWriter(generic.NopWriteCloser(&out1), JSONEncoder[person]{})
+
+	p2 := NewPipeline[person, string](names)
+	out2 := bytes.Buffer{}
+	p2.AddWriter(generic.NopWriteCloser(&out2), Printer[string]{})
+	Join(p1, p2)
+
+	Run(context.Background(), p1, p2)
+
+	assert.Equal(t, "{\"name\":\"bob\",\"age\":31}\n{\"name\":\"alice\",\"age\":41}\n", out1.String())
+	assert.Equal(t, "BOBALICE", out2.String())
+}
+
+// This test checks that the options of the untyped pipeline configure typed pipelines
+func TestOptions(t *testing.T) {
+	reader := &sliceReader{msgs: [][]byte{[]byte(`"a"`), []byte(`"b"`), []byte(`"a"`)}}
+	upper := ProcessorFunc[string, string](func(ctx context.Context, s string) (string, error) {
+		return strings.ToUpper(s), nil
+	})
+	dedup := generic.WithDedup(generic.DedupConfig{ID: func(payload interface{}) string { return payload.(string) }})
+	p := NewPipeline[string, string](upper, dedup)
+	p.AddMessageSource(reader, JSONDecoder[string]{})
+	out := bytes.Buffer{}
+	p.AddWriter(generic.NopWriteCloser(&out), Printer[string]{})
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, "AB", out.String())
+	assert.Equal(t, uint64(1), p.Untyped().Duplicates())
+}
+
+func TestTypeMismatch(t *testing.T) {
+	proc := processorAdapter[person, string]{proc: ProcessorFunc[person, string](func(ctx context.Context, p person) (string, error) {
+		return p.Name, nil
+	})}
+	_, err := proc.Process(context.Background(), "not a person")
+	require.Error(t, err)
+
+	enc := encoderAdapter[string]{enc: Printer[string]{}}
+	_, err = enc.Encode(1)
+	require.Error(t, err)
+}
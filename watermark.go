@@ -0,0 +1,181 @@
+package generic
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+// WatermarkReader can be implemented by inputs which track their own progress in event time. The watermark is the
+// event time before which the input will not deliver any more payloads.
+type WatermarkReader interface {
+	Watermark() time.Time
+}
+
+// watermarker is implemented by the readers of joined pipelines to report the watermark of the upstream pipelines
+type watermarker interface {
+	watermark() (time.Time, bool)
+}
+
+// WithWatermarks tracks a watermark for each input of the pipeline from the event times of the payloads it reads.
+// The event time is returned by timestamp, or taken from the message metadata if timestamp is nil. The watermark of
+// an input trails the latest event time it has read by maxOutOfOrder, to allow for payloads arriving out of order.
+func WithWatermarks(timestamp func(payload interface{}) time.Time, maxOutOfOrder time.Duration) Option {
+	return func(p *Pipeline) {
+		p.watermarks = &watermarks{
+			timestamp:     timestamp,
+			maxOutOfOrder: maxOutOfOrder,
+			sources:       make(map[pipeReader]time.Time),
+		}
+	}
+}
+
+// watermarks holds the watermark of each reader of a pipeline
+type watermarks struct {
+	timestamp     func(payload interface{}) time.Time
+	maxOutOfOrder time.Duration
+
+	mu      sync.Mutex
+	sources map[pipeReader]time.Time
+	// finished is the latest watermark of the readers that reached EOF
+	finished time.Time
+}
+
+// advance moves the watermark of the reader forward to the event time of the item
+func (w *watermarks) advance(r pipeReader, it item) {
+	var t time.Time
+	if w.timestamp != nil {
+		t = w.timestamp(it.payload)
+	} else if it.msg != nil {
+		t = it.msg.EventTime
+	}
+	if t.IsZero() {
+		return
+	}
+	t = t.Add(-w.maxOutOfOrder)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.After(w.sources[r]) {
+		w.sources[r] = t
+	}
+}
+
+// get returns the watermark of the reader, which is the zero time until it reads its first payload
+func (w *watermarks) get(r pipeReader) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sources[r]
+}
+
+// finish stops tracking the reader once it reaches EOF so that it no longer holds back the watermark
+func (w *watermarks) finish(r pipeReader) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t := w.sources[r]; t.After(w.finished) {
+		w.finished = t
+	}
+	delete(w.sources, r)
+}
+
+// advanceWatermark moves the watermark of the reader forward if the pipeline tracks watermarks
+func (p *Pipeline) advanceWatermark(r pipeReader, it item) {
+	if p.watermarks != nil {
+		p.watermarks.advance(r, it)
+	}
+}
+
+// Watermark returns the minimum watermark of the inputs of the pipeline, including the watermarks of the pipelines
+// joined to it. It is the zero time if none of the inputs track a watermark.
+func (p *Pipeline) Watermark() time.Time {
+	t, _ := p.watermark()
+	return t
+}
+
+// watermark returns the minimum watermark of the readers and whether any of them track a watermark
+func (p *Pipeline) watermark() (time.Time, bool) {
+	p.readerLock.Lock()
+	readers := make([]pipeReader, len(p.readers))
+	copy(readers, p.readers)
+	p.readerLock.Unlock()
+
+	var (
+		min   time.Time
+		found bool
+	)
+	for _, r := range readers {
+		if t, ok := p.readerWatermark(r); ok && (!found || t.Before(min)) {
+			min, found = t, true
+		}
+	}
+	if !found && p.watermarks != nil {
+		// All readers have reached EOF
+		p.watermarks.mu.Lock()
+		defer p.watermarks.mu.Unlock()
+		return p.watermarks.finished, true
+	}
+	return min, found
+}
+
+// readerWatermark returns the watermark of a single reader and whether it has one. Readers of joined pipelines
+// whose upstream pipelines do not track a watermark fall back to the watermark tracked by this pipeline.
+func (p *Pipeline) readerWatermark(r pipeReader) (time.Time, bool) {
+	switch rr := r.(type) {
+	case watermarker:
+		if t, ok := rr.watermark(); ok {
+			return t, true
+		}
+	case WatermarkReader:
+		return rr.Watermark(), true
+	case *bufferReader:
+		if wr, ok := rr.r.(WatermarkReader); ok {
+			return wr.Watermark(), true
+		}
+	case *messageInput:
+		if wr, ok := rr.r.(WatermarkReader); ok {
+			return wr.Watermark(), true
+		}
+	case *envelopeInput:
+		if wr, ok := rr.r.(WatermarkReader); ok {
+			return wr.Watermark(), true
+		}
+	}
+	if p.watermarks != nil {
+		return p.watermarks.get(r), true
+	}
+	return time.Time{}, false
+}
+
+// SetLateOutput sets the side output of the pipeline for results which arrive after the windows they belong to have
+// closed, including their allowed lateness. Without a late output, late results are dropped and only counted.
+func (p *Pipeline) SetLateOutput(w io.WriteCloser, enc pencode.Encoder) {
+	p.lateOutput = &sink{out: pipeOutput{w: w, enc: enc}}
+}
+
+// Late returns the number of results that arrived after their windows closed
+func (p *Pipeline) Late() uint64 {
+	return atomic.LoadUint64(&p.late)
+}
+
+// writeLate counts the late results and writes them to the late output if one is set
+func (p *Pipeline) writeLate(r pipeReader, it item, late Results) {
+	if len(late) == 0 {
+		return
+	}
+	atomic.AddUint64(&p.late, uint64(len(late)))
+	if p.lateOutput == nil {
+		p.log.Println("Dropping late results from reader", readerName(r))
+		return
+	}
+
+	p.lateOutput.mu.Lock()
+	defer p.lateOutput.mu.Unlock()
+	for _, result := range late {
+		if _, err := p.lateOutput.out.Write(it.output(result)); err != nil {
+			p.log.Error("Error writing late result: %s", err)
+		}
+	}
+}
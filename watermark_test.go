@@ -0,0 +1,111 @@
+package generic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lobocv/pipeline/pencode"
+)
+
+func TestWatermarks(t *testing.T) {
+	p := newQuietPipeline(WithWatermarks(eventTime, 2*time.Second))
+	a, b := &sliceSource{}, &sliceSource{}
+	p.AddSource(a)
+	p.AddSource(b)
+
+	// Inputs that have not read anything hold back the watermark
+	p.advanceWatermark(a, item{payload: event{at: 10}})
+	assert.True(t, p.Watermark().IsZero())
+
+	p.advanceWatermark(b, item{payload: event{at: 20}})
+	assert.Equal(t, time.Unix(8, 0), p.Watermark())
+
+	// Watermarks never move backwards
+	p.advanceWatermark(a, item{payload: event{at: 5}})
+	assert.Equal(t, time.Unix(8, 0), p.Watermark())
+
+	// Inputs that reach EOF no longer hold back the watermark
+	p.RemoveReader(a)
+	assert.Equal(t, time.Unix(18, 0), p.Watermark())
+	p.RemoveReader(b)
+	assert.Equal(t, time.Unix(18, 0), p.Watermark())
+}
+
+// This test checks that joined pipelines report the minimum watermark of their upstream pipelines
+func TestJoinWatermarks(t *testing.T) {
+	var ups []*Pipeline
+	for _, at := range []int{30, 10} {
+		up := newQuietPipeline(WithWatermarks(eventTime, 0))
+		src := &sliceSource{}
+		up.AddSource(src)
+		up.advanceWatermark(src, item{payload: event{at: at}})
+		ups = append(ups, up)
+	}
+
+	joined := newQuietPipeline()
+	ups[0].Join(joined)
+	assert.Equal(t, time.Unix(30, 0), joined.Watermark())
+
+	merged := newQuietPipeline()
+	merged.Merge(ups...)
+	assert.Equal(t, time.Unix(10, 0), merged.Watermark())
+
+	// Pipelines without watermarks do not report one
+	assert.True(t, newQuietPipeline().Watermark().IsZero())
+}
+
+func TestLateResults(t *testing.T) {
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: []interface{}{
+		event{"a", 1}, event{"a", 12}, event{"a", 3}, event{"a", 20}, event{"a", 4},
+	}})
+	p.SetProcessor(identity)
+	p.SetWindow(NewTumblingWindow(10*time.Second, count, WithEventTime(eventTime), AllowedLateness(5*time.Second)))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+	late := &bufferCloser{}
+	p.SetLateOutput(late, pencode.Printer{})
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, []interface{}{
+		// The event at 3 arrived within the allowed lateness
+		window("", 0, 10, 2), window("", 10, 20, 1), window("", 20, 30, 1),
+	}, out.results)
+	// The event at 4 arrived after the window closed
+	assert.Equal(t, []string{"{a 4}"}, late.writes)
+	assert.Equal(t, uint64(1), p.Late())
+}
+
+// This test checks that a joined pipeline tracks its own watermarks if its upstream pipeline does not
+func TestJoinLateResults(t *testing.T) {
+	events := []interface{}{event{"a", 1}, event{"a", 25}, event{"a", 3}}
+	newWindowed := func() (*Pipeline, *collectWriter) {
+		p := newQuietPipeline(WithWatermarks(eventTime, 0))
+		p.SetProcessor(identity)
+		p.SetWindow(NewTumblingWindow(10*time.Second, count, WithEventTime(eventTime)))
+		out := &collectWriter{}
+		p.writers = append(p.writers, out)
+		return p, out
+	}
+
+	p, out := newWindowed()
+	p.AddSource(&sliceSource{payloads: events})
+	require.NoError(t, p.Run(context.Background()))
+
+	up := newQuietPipeline()
+	up.AddSource(&sliceSource{payloads: events})
+	up.SetProcessor(identity)
+	down, joinedOut := newWindowed()
+	up.Join(down)
+	require.NoError(t, Run(context.Background(), up, down))
+
+	expected := []interface{}{window("", 0, 10, 1), window("", 20, 30, 1)}
+	assert.Equal(t, expected, out.results)
+	assert.Equal(t, uint64(1), p.Late())
+	assert.Equal(t, expected, joinedOut.results)
+	assert.Equal(t, uint64(1), down.Late())
+}
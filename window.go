@@ -0,0 +1,338 @@
+package generic
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Aggregate folds a result into the accumulated value of a window. acc is nil for the first result of a window.
+type Aggregate func(acc, result interface{}) interface{}
+
+// WindowResult is written to the writers of a pipeline when a window closes
+type WindowResult struct {
+	// Key is the key of the results in the window
+	Key string
+	// Start and End are the bounds of the window. For session windows, End is the time of the last result plus the gap.
+	Start time.Time
+	End   time.Time
+	// Value is the aggregate of the results in the window
+	Value interface{}
+}
+
+type windowKind int
+
+const (
+	tumbling windowKind = iota
+	sliding
+	session
+)
+
+// WindowOption configures a Window
+type WindowOption func(w *Window)
+
+// KeyBy groups results into separate windows by the key returned by key
+func KeyBy(key func(result interface{}) string) WindowOption {
+	return func(w *Window) {
+		w.key = key
+	}
+}
+
+// WithEventTime assigns results to windows by the time returned by eventTime rather than by the time they were
+// processed. Event time windows close once the watermark of the pipeline passes their end, or if the pipeline does not
+// track a watermark, once a result with a later event time has been seen.
+func WithEventTime(eventTime func(result interface{}) time.Time) WindowOption {
+	return func(w *Window) {
+		w.eventTime = eventTime
+	}
+}
+
+// AllowedLateness keeps event time windows open for the given time after the watermark passes their end, so that
+// results arriving out of order are still included. Results arriving after that are late and written to the late
+// output of the pipeline instead.
+func AllowedLateness(lateness time.Duration) WindowOption {
+	return func(w *Window) {
+		w.lateness = lateness
+	}
+}
+
+// windowID identifies a tumbling or sliding window
+type windowID struct {
+	key   string
+	start time.Time
+}
+
+// Window groups the results of a pipeline into windows of time and aggregates them. Instead of writing each result,
+// the pipeline writes a WindowResult when each window closes. All remaining windows are closed when the pipeline
+// stops running.
+type Window struct {
+	kind      windowKind
+	size      time.Duration
+	slide     time.Duration
+	aggregate Aggregate
+	key       func(result interface{}) string
+	eventTime func(result interface{}) time.Time
+	lateness  time.Duration
+
+	mu       sync.Mutex
+	open     map[windowID]*WindowResult
+	sessions map[string]*WindowResult
+	// latest is the latest event time seen
+	latest time.Time
+}
+
+// NewTumblingWindow creates fixed size, non-overlapping windows
+func NewTumblingWindow(size time.Duration, agg Aggregate, opts ...WindowOption) *Window {
+	return newWindow(tumbling, size, size, agg, opts)
+}
+
+// NewSlidingWindow creates windows of the given size which start every slide, so that each result belongs to
+// size / slide windows
+func NewSlidingWindow(size, slide time.Duration, agg Aggregate, opts ...WindowOption) *Window {
+	return newWindow(sliding, size, slide, agg, opts)
+}
+
+// NewSessionWindow creates windows which stay open as long as results keep arriving within the gap of each other
+func NewSessionWindow(gap time.Duration, agg Aggregate, opts ...WindowOption) *Window {
+	return newWindow(session, gap, gap, agg, opts)
+}
+
+func newWindow(kind windowKind, size, slide time.Duration, agg Aggregate, opts []WindowOption) *Window {
+	w := &Window{kind: kind, size: size, slide: slide, aggregate: agg,
+		open: make(map[windowID]*WindowResult), sessions: make(map[string]*WindowResult)}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// SetWindow sets the window that the results of the pipeline are aggregated into
+func (p *Pipeline) SetWindow(w *Window) {
+	p.window = w
+}
+
+// add assigns the results to their windows and returns any windows that closed as a result, along with the results
+// which arrived after their windows closed. watermark is the watermark of the pipeline and tracked is false if the
+// pipeline does not track one.
+func (w *Window) add(results Results, now, watermark time.Time, tracked bool) (closed, late Results) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, result := range results {
+		payload := payloadOf(result)
+		t := now
+		if w.eventTime != nil {
+			t = w.eventTime(payload)
+			if t.After(w.latest) {
+				w.latest = t
+			}
+			if w.isLate(t, w.eventNow(watermark, tracked)) {
+				late = append(late, result)
+				continue
+			}
+		}
+		var key string
+		if w.key != nil {
+			key = w.key(payload)
+		}
+
+		if w.kind == session {
+			w.addToSession(key, t, payload)
+			continue
+		}
+		for _, start := range w.starts(t) {
+			id := windowID{key: key, start: start}
+			win, ok := w.open[id]
+			if !ok {
+				win = &WindowResult{Key: key, Start: start, End: start.Add(w.size)}
+				w.open[id] = win
+			}
+			win.Value = w.aggregate(win.Value, payload)
+		}
+	}
+	return w.expire(now, watermark, tracked), late
+}
+
+// isLate returns true if all the windows that contain event time t have closed by the event time now
+func (w *Window) isLate(t, now time.Time) bool {
+	end := t.Add(w.size)
+	if w.kind != session {
+		end = t.Truncate(w.slide).Add(w.size)
+	}
+	return !end.After(now)
+}
+
+// eventNow returns the event time up to which windows are closed. It is the watermark of the pipeline, or the latest
+// event time seen if the pipeline does not track one, less the allowed lateness.
+func (w *Window) eventNow(watermark time.Time, tracked bool) time.Time {
+	if !tracked {
+		watermark = w.latest
+	}
+	return watermark.Add(-w.lateness)
+}
+
+// addToSession adds the payload to the session of the key that t falls within the gap of, closing the previous
+// session if the gap has passed. Results arriving out of order before the current session are added to an earlier
+// session or start one of their own.
+func (w *Window) addToSession(key string, t time.Time, payload interface{}) {
+	s, ok := w.sessions[key]
+	switch {
+	case ok && w.inSession(s, t):
+	case !ok || !t.Before(s.End):
+		if ok {
+			// Sessions which have passed their gap are closed by expire, keep them under their own ID until then
+			w.open[windowID{key: key, start: s.Start}] = s
+		}
+		s = &WindowResult{Key: key, Start: t, End: t.Add(w.size)}
+		w.sessions[key] = s
+	default:
+		s = w.earlierSession(key, t)
+	}
+	if t.Before(s.Start) {
+		s.Start = t
+	}
+	if end := t.Add(w.size); end.After(s.End) {
+		s.End = end
+	}
+	s.Value = w.aggregate(s.Value, payload)
+}
+
+// inSession returns true if t is within the gap of the session
+func (w *Window) inSession(s *WindowResult, t time.Time) bool {
+	return !t.Before(s.Start.Add(-w.size)) && t.Before(s.End)
+}
+
+// earlierSession returns the session of the key before the current one that t falls within the gap of, starting a
+// new session if there is none
+func (w *Window) earlierSession(key string, t time.Time) *WindowResult {
+	for _, s := range w.open {
+		if s.Key == key && w.inSession(s, t) {
+			return s
+		}
+	}
+	s := &WindowResult{Key: key, Start: t, End: t.Add(w.size)}
+	w.open[windowID{key: key, start: t}] = s
+	return s
+}
+
+// starts returns the start times of the windows that contain t
+func (w *Window) starts(t time.Time) []time.Time {
+	var starts []time.Time
+	for start := t.Truncate(w.slide); start.Add(w.size).After(t); start = start.Add(-w.slide) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// expire closes and returns the windows which ended before the current time, which is the processing time or the
+// current event time
+func (w *Window) expire(now, watermark time.Time, tracked bool) Results {
+	if w.eventTime != nil {
+		now = w.eventNow(watermark, tracked)
+	}
+	return w.close(func(win *WindowResult) bool {
+		return !win.End.After(now)
+	})
+}
+
+// flush closes and returns all the windows
+func (w *Window) flush() Results {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close(func(*WindowResult) bool { return true })
+}
+
+// close removes and returns the windows for which done returns true, ordered by their end and key
+func (w *Window) close(done func(win *WindowResult) bool) Results {
+	var closed []*WindowResult
+	for id, win := range w.open {
+		if done(win) {
+			closed = append(closed, win)
+			delete(w.open, id)
+		}
+	}
+	for key, s := range w.sessions {
+		if done(s) {
+			closed = append(closed, s)
+			delete(w.sessions, key)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		if closed[i].End.Equal(closed[j].End) {
+			return closed[i].Key < closed[j].Key
+		}
+		return closed[i].End.Before(closed[j].End)
+	})
+
+	results := make(Results, 0, len(closed))
+	for _, win := range closed {
+		results = append(results, *win)
+	}
+	return results
+}
+
+// tick returns how often processing time windows are checked for closing
+func (w *Window) tick() time.Duration {
+	tick := w.slide / 4
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	return tick
+}
+
+// startWindow periodically writes the windows that have closed until the returned function is called, which writes
+// all remaining windows
+func (p *Pipeline) startWindow(ctx context.Context) (stop func()) {
+	if p.window == nil {
+		return func() {}
+	}
+	stopTicker := p.every(p.window.tick(), func(now time.Time) {
+		watermark, tracked := p.watermark()
+		p.window.mu.Lock()
+		closed := p.window.expire(now, watermark, tracked)
+		p.window.mu.Unlock()
+		p.writeWindows(ctx, closed)
+	})
+	return func() {
+		stopTicker()
+		p.writeWindows(ctx, p.window.flush())
+	}
+}
+
+// every calls fn at the given interval in a separate goroutine until the returned function is called. A non-positive
+// interval never calls fn.
+func (p *Pipeline) every(interval time.Duration, fn func(now time.Time)) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				fn(now)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// writeWindows writes the closed windows
+func (p *Pipeline) writeWindows(ctx context.Context, closed Results) {
+	if len(closed) == 0 {
+		return
+	}
+	if err := p.writeAll(ctx, nil, item{}, closed); err != nil {
+		p.handleError(ctx, err)
+	}
+}
@@ -0,0 +1,108 @@
+package generic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// event is a test payload with an event time in seconds
+type event struct {
+	key string
+	at  int
+}
+
+func eventTime(result interface{}) time.Time {
+	return time.Unix(int64(result.(event).at), 0)
+}
+
+func eventKey(result interface{}) string {
+	return result.(event).key
+}
+
+// count aggregates the number of results in a window
+func count(acc, result interface{}) interface{} {
+	if acc == nil {
+		return 1
+	}
+	return acc.(int) + 1
+}
+
+func runWindow(t *testing.T, w *Window, events ...interface{}) []interface{} {
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: events})
+	p.SetProcessor(identity)
+	p.SetWindow(w)
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+	require.NoError(t, p.Run(context.Background()))
+	return out.results
+}
+
+func window(key string, start, end int, value interface{}) WindowResult {
+	return WindowResult{Key: key, Start: time.Unix(int64(start), 0), End: time.Unix(int64(end), 0), Value: value}
+}
+
+func TestTumblingWindow(t *testing.T) {
+	w := NewTumblingWindow(10*time.Second, count, KeyBy(eventKey), WithEventTime(eventTime))
+	results := runWindow(t, w,
+		event{"a", 1}, event{"b", 2}, event{"a", 9}, event{"a", 10}, event{"b", 25}, event{"a", 31},
+	)
+	assert.Equal(t, []interface{}{
+		// Closed as later events arrived
+		window("a", 0, 10, 2), window("b", 0, 10, 1), window("a", 10, 20, 1),
+		// Closed when the pipeline stopped
+		window("b", 20, 30, 1), window("a", 30, 40, 1),
+	}, results)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	w := NewSlidingWindow(10*time.Second, 5*time.Second, count, WithEventTime(eventTime))
+	results := runWindow(t, w, event{"a", 1}, event{"a", 7}, event{"a", 12})
+	assert.Equal(t, []interface{}{
+		window("", -5, 5, 1), window("", 0, 10, 2), window("", 5, 15, 2), window("", 10, 20, 1),
+	}, results)
+}
+
+func TestSessionWindow(t *testing.T) {
+	w := NewSessionWindow(5*time.Second, count, KeyBy(eventKey), WithEventTime(eventTime))
+	results := runWindow(t, w,
+		event{"a", 1}, event{"a", 4}, event{"b", 5}, event{"a", 8}, event{"a", 20}, event{"b", 21},
+	)
+	assert.Equal(t, []interface{}{
+		window("b", 5, 10, 1), window("a", 1, 13, 3), window("a", 20, 25, 1), window("b", 21, 26, 1),
+	}, results)
+
+	// Results arriving out of order before the current session belong to an earlier session of their own
+	w = NewSessionWindow(5*time.Second, count, WithEventTime(eventTime), AllowedLateness(time.Minute))
+	results = runWindow(t, w, event{"a", 100}, event{"a", 80}, event{"a", 82}, event{"a", 97})
+	assert.Equal(t, []interface{}{window("", 80, 87, 2), window("", 97, 105, 2)}, results)
+}
+
+// This test checks that processing time windows close on their own while the pipeline is running
+func TestProcessingTimeWindow(t *testing.T) {
+	blocked := make(blockingReader)
+	defer close(blocked)
+
+	p := newQuietPipeline()
+	p.AddSource(&sliceSource{payloads: []interface{}{1, 2, 3}})
+	p.readers = append(p.readers, blocked)
+	p.SetProcessor(identity)
+	p.SetWindow(NewTumblingWindow(20*time.Millisecond, count))
+	out := &collectWriter{}
+	p.writers = append(p.writers, out)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = p.Run(ctx)
+
+	require.NotEmpty(t, out.results)
+	total := 0
+	for _, result := range out.results {
+		total += result.(WindowResult).Value.(int)
+	}
+	assert.Equal(t, 3, total)
+}
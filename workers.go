@@ -0,0 +1,193 @@
+package generic
+
+import (
+	"context"
+	"sync"
+)
+
+// item is a payload travelling through the pipeline along with the raw input it was decoded from and its
+// message metadata, if any
+type item struct {
+	raw     []byte
+	payload interface{}
+	msg     *Message
+}
+
+// output returns what should be written for the result of processing the item. Results of items with metadata are
+// wrapped in a copy of the message so that the metadata reaches the writers.
+func (it item) output(result interface{}) interface{} {
+	if it.msg == nil {
+		return result
+	}
+	out := *it.msg
+	out.Payload = result
+	return &out
+}
+
+// job is an item that is waiting to be processed by a worker
+type job struct {
+	item
+	out chan<- processed
+}
+
+// processed is the outcome of processing a single item
+type processed struct {
+	item
+	result interface{}
+	err    error
+}
+
+// listenConcurrent runs the read, process and write stages for a given pipeReader in separate goroutines which are
+// connected by bounded buffers. Payloads are fanned out to a pool of workers for processing and their results are
+// written by a single goroutine, either in input order or as soon as they are ready.
+func (p *Pipeline) listenConcurrent(ctx context.Context, r pipeReader) {
+	numWorkers := p.workers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	inputCfg, outputCfg := p.stageBuffer, p.stageBuffer
+	if inputCfg.size == 0 {
+		inputCfg.size = numWorkers
+		outputCfg.size = numWorkers
+	}
+
+	var (
+		input   = newBuffer(inputCfg, &p.dropped)
+		output  = newBuffer(outputCfg, &p.dropped)
+		jobs    = make(chan job, numWorkers)
+		pending = make(chan chan processed, numWorkers)
+		results = make(chan processed, numWorkers)
+		workers sync.WaitGroup
+		stages  sync.WaitGroup
+	)
+
+	p.log.Println("Starting reader with", numWorkers, "workers")
+
+	// Dispatch payloads from the input buffer to the workers
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		for it := range input.items {
+			out := results
+			if p.ordering == Ordered {
+				out = make(chan processed, 1)
+			}
+			jobs <- job{item: it.(item), out: out}
+			if p.ordering == Ordered {
+				pending <- out
+			}
+		}
+		close(jobs)
+		close(pending)
+	}()
+
+	for ii := 0; ii < numWorkers; ii++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				result, err := p.process(ctx, r, j.item)
+				j.out <- processed{item: j.item, result: result, err: err}
+			}
+		}()
+	}
+
+	// Collect the results from the workers into the output buffer
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		defer close(output.items)
+		if p.ordering == Ordered {
+			for out := range pending {
+				p.pushProcessed(ctx, r, output, <-out)
+			}
+			return
+		}
+		for res := range results {
+			p.pushProcessed(ctx, r, output, res)
+		}
+	}()
+
+	// Write the results from the output buffer
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		for v := range output.items {
+			res := v.(processed)
+			if err := p.emit(ctx, r, res.item, res.result); err != nil {
+				p.forget(res.item)
+				p.handleError(ctx, err)
+			}
+		}
+	}()
+
+	done := p.readInto(ctx, r, input)
+
+	// Wait for all in-flight payloads to be processed and written
+	close(input.items)
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+	stages.Wait()
+
+	if done {
+		p.RemoveReader(r)
+	}
+	p.log.Println("Stopping reader")
+}
+
+// readInto performs blocking reads on the pipeReader and pushes the payloads into the buffer until the reader
+// reaches EOF or the context is canceled. It returns true if the reader reached EOF or was stopped by the error policy.
+func (p *Pipeline) readInto(ctx context.Context, r pipeReader, input *buffer) bool {
+	for {
+		if !p.awaitReader(ctx, r) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			p.log.Println("Stopping reading from reader")
+			return false
+		case <-p.stopped:
+			p.log.Println("Stopping reading from reader")
+			return false
+		case <-p.drainSignal(r):
+			p.log.Println("Stopping reading from reader")
+			return false
+		default:
+		}
+
+		it, err := p.read(ctx, r)
+		if err != nil {
+			if err == EOF {
+				p.log.Println("Reader reached EOF")
+				return true
+			}
+			p.log.Error("Error during read: %s", err)
+			p.handleError(ctx, err)
+			continue
+		}
+		p.advanceWatermark(r, it)
+		// Payloads dropped by a rate limit are not remembered by dedup, so that their redelivery is processed
+		if !p.admit(ctx, r) || p.duplicate(it) {
+			continue
+		}
+
+		if err = input.push(ctx, it); err != nil && err != ctx.Err() {
+			p.handleError(ctx, err)
+		}
+	}
+}
+
+// pushProcessed pushes a processed item into the output buffer or reports the processing error
+func (p *Pipeline) pushProcessed(ctx context.Context, r pipeReader, output *buffer, res processed) {
+	if res.err != nil {
+		p.log.Error("Error during processing: %s", res.err)
+		p.forget(res.item)
+		p.handleError(ctx, stageError(StageProcess, r, nil, res.err))
+		return
+	}
+	if err := output.push(ctx, res); err != nil && err != ctx.Err() {
+		p.handleError(ctx, err)
+	}
+}